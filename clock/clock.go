@@ -0,0 +1,142 @@
+// Package clock abstracts reading the time, so that code measuring time, such as counters and
+// telemetries, can be driven by a fake clock in tests.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc calls f once d elapsed on the clock, unless the returned timer is stopped first.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call scheduled by AfterFunc.
+type Timer interface {
+	// Stop prevents the call, returning false if it already happened or was already stopped.
+	Stop() bool
+}
+
+type realClock struct{}
+
+// Now returns time.Now(), whose monotonic clock reading keeps durations between two readings
+// correct when the wall clock is changed.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Real is the system clock, the default of everything taking a Clock.
+var Real Clock = realClock{}
+
+// Fake is a clock whose time only changes when it is advanced or set.
+type Fake struct {
+	now    time.Time
+	timers []*fakeTimer
+	mu     sync.Mutex
+}
+
+type fakeTimer struct {
+	fake *Fake
+	at   time.Time
+	f    func()
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (self *Fake) Now() time.Time {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.now
+}
+
+// AfterFunc schedules f for when the clock is advanced or set d past its current time. Unlike with
+// the real clock, due calls are made synchronously by Advance and Set, in the order they are due,
+// which keeps tests deterministic; calls due right away are made in their own goroutine.
+func (self *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	timer := &fakeTimer{fake: self, at: self.now.Add(d), f: f}
+
+	if d <= 0 {
+		go f()
+		return timer
+	}
+
+	self.timers = append(self.timers, timer)
+
+	return timer
+}
+
+// Advance moves the clock forward by d, making the calls that became due.
+func (self *Fake) Advance(d time.Duration) {
+	self.mu.Lock()
+	self.now = self.now.Add(d)
+	self.mu.Unlock()
+
+	self.fire()
+}
+
+// Set sets the time of the clock, making the calls that became due.
+func (self *Fake) Set(now time.Time) {
+	self.mu.Lock()
+	self.now = now
+	self.mu.Unlock()
+
+	self.fire()
+}
+
+func (self *Fake) fire() {
+
+	self.mu.Lock()
+
+	var due []*fakeTimer
+
+	pending := self.timers[:0]
+
+	for _, timer := range self.timers {
+		if timer.at.After(self.now) {
+			pending = append(pending, timer)
+		} else {
+			due = append(due, timer)
+		}
+	}
+
+	self.timers = pending
+
+	// calls are made without the lock so that they can read the clock and schedule more calls
+	self.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].at.Before(due[j].at)
+	})
+
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+func (self *fakeTimer) Stop() bool {
+	self.fake.mu.Lock()
+	defer self.fake.mu.Unlock()
+
+	for i, timer := range self.fake.timers {
+		if timer == self {
+			self.fake.timers = append(self.fake.timers[:i], self.fake.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
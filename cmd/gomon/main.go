@@ -0,0 +1,77 @@
+// Command gomon inspects the counters of processes instrumented with gomon.
+//
+// Usage:
+//
+//	gomon top [-url <url>] [-interval <d>] [-filter <regexp>] [-n <rows>]
+//	gomon record [-url <url>] [-interval <d>] [-filter <regexp>] [-o <file>] [-count <n>]
+//	gomon replay [-filter <regexp>] [-n <rows>] [-speed <x>] <file or dir>
+//	gomon query -dir <dir> [-from <time>] [-to <time>] [-csv] <series>...
+//	gomon series -dir <dir>
+//
+// top polls the expvar endpoint of a process, /debug/vars, and shows a refreshing table of its
+// numeric values with their change since the previous poll, rate per second and recent history.
+// record polls the same way and writes a CSV table, one column per counter, like typeperf. replay
+// shows the table of such a CSV file, or of a recorder directory, at the recorded pace.
+//
+// query prints the points of series recorded by a perfcounters/recorder.Recorder, and series
+// lists the recorded series. Times are RFC 3339 timestamps, or durations relative to now such as
+// "-1h".
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"top":    {"top [-url <url>] [-interval <d>] [-filter <regexp>] [-n <rows>]", runTop},
+	"record": {"record [-url <url>] [-interval <d>] [-filter <regexp>] [-o <file>] [-count <n>]", runRecord},
+	"replay": {"replay [-filter <regexp>] [-n <rows>] [-speed <x>] <file or dir>", runReplay},
+	"query":  {"query -dir <dir> [-from <time>] [-to <time>] [-csv] <series>...", runQuery},
+	"series": {"series -dir <dir>", runSeries},
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command, ok := commands[os.Args[1]]
+
+	if !ok {
+		usage()
+	}
+
+	if err := command.run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "gomon:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+
+	for i, name := range names {
+		lines[i] = "  gomon " + commands[name].usage
+	}
+
+	fmt.Fprintf(os.Stderr, "usage:\n%s\n", strings.Join(lines, "\n"))
+	os.Exit(2)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/israelchen/gomon/perfcounters/recorder"
+	"os"
+	"strconv"
+	"time"
+)
+
+func runQuery(args []string) error {
+
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	dir := flags.String("dir", "", "directory of the recorded files")
+	from := flags.String("from", "", "start of the range, RFC 3339 or relative to now like -1h")
+	to := flags.String("to", "", "end of the range, RFC 3339 or relative to now like -5m")
+	asCSV := flags.Bool("csv", false, "print a CSV table with a column per series")
+	flags.Parse(args)
+
+	if len(*dir) == 0 || flags.NArg() == 0 {
+		return errors.New("query needs -dir and at least one series")
+	}
+
+	now := time.Now()
+	fromTime, err := parseTime(*from, now)
+
+	if err != nil {
+		return err
+	}
+
+	toTime, err := parseTime(*to, now)
+
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]int)
+
+	for i, series := range flags.Args() {
+		wanted[series] = i
+	}
+
+	if !*asCSV {
+		return recorder.Scan(*dir, fromTime, toTime, func(at time.Time, series string, value float64) error {
+			if _, ok := wanted[series]; ok {
+				_, err := fmt.Printf("%s\t%s\t%s\n", at.Format(time.RFC3339Nano), series, formatValue(value))
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	// one row per tick, like typeperf.
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write(append([]string{"time"}, flags.Args()...))
+
+	var row []string
+	var rowTime time.Time
+
+	flush := func() {
+		if row != nil {
+			writer.Write(row)
+		}
+	}
+
+	err = recorder.Scan(*dir, fromTime, toTime, func(at time.Time, series string, value float64) error {
+		column, ok := wanted[series]
+
+		if !ok {
+			return nil
+		}
+
+		if row == nil || !at.Equal(rowTime) {
+			flush()
+			row = make([]string, len(wanted)+1)
+			row[0] = at.Format(time.RFC3339Nano)
+			rowTime = at
+		}
+
+		row[column+1] = formatValue(value)
+		return nil
+	})
+
+	flush()
+	writer.Flush()
+
+	if err != nil {
+		return err
+	}
+
+	return writer.Error()
+}
+
+func runSeries(args []string) error {
+
+	flags := flag.NewFlagSet("series", flag.ExitOnError)
+	dir := flags.String("dir", "", "directory of the recorded files")
+	flags.Parse(args)
+
+	if len(*dir) == 0 {
+		return errors.New("series needs -dir")
+	}
+
+	names, err := recorder.Series(*dir)
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+
+	return err
+}
+
+// parseTime parses an RFC 3339 timestamp or a duration relative to now. An empty string is the
+// zero time, leaving a range open.
+func parseTime(s string, now time.Time) (time.Time, error) {
+
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/israelchen/gomon/perfcounters/recorder"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// frame is the values of the counters at a point in time.
+type frame struct {
+	time   time.Time
+	values map[string]float64
+}
+
+func runRecord(args []string) error {
+
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080/debug/vars", "expvar endpoint of the target process")
+	interval := flags.Duration("interval", 2*time.Second, "time between polls")
+	filter := flags.String("filter", "", "regular expression counter names must match")
+	output := flags.String("o", "", "CSV file to write, standard output by default")
+	count := flags.Int("count", 0, "number of polls to record, unlimited by default")
+	flags.Parse(args)
+
+	pattern, err := regexp.Compile(*filter)
+
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+
+	if len(*output) > 0 {
+		file, err := os.Create(*output)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		out = file
+	}
+
+	// like typeperf, the columns are the counters found by the first poll.
+	writer := csv.NewWriter(out)
+	var names []string
+
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+
+		values, err := poll(*url)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gomon:", err)
+			continue
+		}
+
+		if names == nil {
+			names = []string{}
+
+			for name := range values {
+				if pattern.MatchString(name) {
+					names = append(names, name)
+				}
+			}
+
+			sort.Strings(names)
+			writer.Write(append([]string{"time"}, names...))
+		}
+
+		row := []string{time.Now().Format(time.RFC3339Nano)}
+
+		for _, name := range names {
+			if value, ok := values[name]; ok {
+				row = append(row, formatValue(value))
+			} else {
+				row = append(row, "")
+			}
+		}
+
+		writer.Write(row)
+		writer.Flush()
+
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runReplay(args []string) error {
+
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	filter := flags.String("filter", "", "regular expression counter names must match")
+	rows := flags.Int("n", 40, "maximum number of counters shown")
+	speed := flags.Float64("speed", 1, "replay speed relative to the recording, 0 to print every frame at once")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("replay needs a CSV file recorded by gomon record, or a recorder directory")
+	}
+
+	pattern, err := regexp.Compile(*filter)
+
+	if err != nil {
+		return err
+	}
+
+	frames, err := readFrames(flags.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	view := newTopView(pattern)
+
+	for i, f := range frames {
+		if *speed > 0 {
+			if i > 0 {
+				time.Sleep(time.Duration(float64(f.time.Sub(frames[i-1].time)) / *speed))
+			}
+
+			fmt.Print("\x1b[H\x1b[2J")
+		}
+
+		view.update(f.time, f.values)
+		fmt.Printf("%s  %s\n\n", f.time.Format(time.RFC3339), flags.Arg(0))
+		view.render(os.Stdout, *rows)
+	}
+
+	return nil
+}
+
+// readFrames reads a CSV file written by record, or the files of a recorder directory.
+func readFrames(path string) ([]frame, error) {
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		var frames []frame
+
+		err := recorder.Scan(path, time.Time{}, time.Time{}, func(at time.Time, series string, value float64) error {
+			if len(frames) == 0 || !frames[len(frames)-1].time.Equal(at) {
+				frames = append(frames, frame{at, make(map[string]float64)})
+			}
+
+			frames[len(frames)-1].values[series] = value
+			return nil
+		})
+
+		return frames, err
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 || len(records[0]) == 0 || records[0][0] != "time" {
+		return nil, errors.New(path + " was not recorded by gomon record")
+	}
+
+	frames := make([]frame, 0, len(records)-1)
+
+	for _, record := range records[1:] {
+		at, err := time.Parse(time.RFC3339Nano, record[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		f := frame{at, make(map[string]float64)}
+
+		for i, cell := range record[1:] {
+			if value, err := strconv.ParseFloat(cell, 64); err == nil {
+				f.values[records[0][i+1]] = value
+			}
+		}
+
+		frames = append(frames, f)
+	}
+
+	return frames, nil
+}
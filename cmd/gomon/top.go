@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sparklineWidth = 20
+
+var sparklineBars = []rune("▁▂▃▄▅▆▇█")
+
+func runTop(args []string) error {
+
+	flags := flag.NewFlagSet("top", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080/debug/vars", "expvar endpoint of the target process")
+	interval := flags.Duration("interval", 2*time.Second, "time between polls")
+	filter := flags.String("filter", "", "regular expression counter names must match")
+	rows := flags.Int("n", 40, "maximum number of counters shown")
+	flags.Parse(args)
+
+	pattern, err := regexp.Compile(*filter)
+
+	if err != nil {
+		return err
+	}
+
+	view := newTopView(pattern)
+
+	for {
+		values, err := poll(*url)
+		now := time.Now()
+
+		// clear the screen and go home before every refresh.
+		fmt.Print("\x1b[H\x1b[2J")
+
+		if err != nil {
+			fmt.Printf("%s  %s: %v\n", now.Format(time.TimeOnly), *url, err)
+		} else {
+			view.update(now, values)
+			fmt.Printf("%s  %s\n\n", now.Format(time.TimeOnly), *url)
+			view.render(os.Stdout, *rows)
+		}
+
+		time.Sleep(*interval)
+	}
+}
+
+// poll fetches an expvar endpoint and flattens it.
+func poll(url string) (map[string]float64, error) {
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(response.Status)
+	}
+
+	var vars map[string]interface{}
+
+	if err := json.NewDecoder(response.Body).Decode(&vars); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64)
+	flatten("", vars, values)
+
+	return values, nil
+}
+
+// flatten collects the numbers of a decoded JSON document under dotted names, e.g.
+// "foo.totalCalls" for {"foo": {"totalCalls": 5}}. Numeric strings, as published by some counters,
+// count as numbers. Arrays are left out.
+func flatten(prefix string, value interface{}, values map[string]float64) {
+
+	switch v := value.(type) {
+	case float64:
+		values[prefix] = v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			values[prefix] = f
+		}
+	case map[string]interface{}:
+		for key, child := range v {
+			if len(prefix) > 0 {
+				key = prefix + "." + key
+			}
+
+			flatten(key, child, values)
+		}
+	}
+}
+
+type topPoint struct {
+	time  time.Time
+	value float64
+}
+
+// topView keeps the recent values of the counters matching a filter, and renders them as a table.
+type topView struct {
+	filter  *regexp.Regexp
+	history map[string][]topPoint
+}
+
+func newTopView(filter *regexp.Regexp) *topView {
+	return &topView{
+		filter:  filter,
+		history: make(map[string][]topPoint),
+	}
+}
+
+func (self *topView) update(at time.Time, values map[string]float64) {
+
+	for name, value := range values {
+		if !self.filter.MatchString(name) {
+			continue
+		}
+
+		points := append(self.history[name], topPoint{at, value})
+
+		if len(points) > sparklineWidth {
+			points = points[len(points)-sparklineWidth:]
+		}
+
+		self.history[name] = points
+	}
+
+	// counters that disappeared, like removed category instances, are dropped.
+	for name := range self.history {
+		if _, ok := values[name]; !ok {
+			delete(self.history, name)
+		}
+	}
+}
+
+func (self *topView) render(w io.Writer, rows int) {
+
+	names := make([]string, 0, len(self.history))
+	width := len("COUNTER")
+
+	for name := range self.history {
+		names = append(names, name)
+
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	sort.Strings(names)
+
+	if len(names) > rows {
+		names = names[:rows]
+	}
+
+	fmt.Fprintf(w, "%-*s  %14s  %12s  %12s  %s\n", width, "COUNTER", "VALUE", "DELTA", "RATE/S", "HISTORY")
+
+	for _, name := range names {
+		points := self.history[name]
+		last := points[len(points)-1]
+		delta, rate := "", ""
+
+		if len(points) > 1 {
+			previous := points[len(points)-2]
+			d := last.value - previous.value
+			delta = formatValue(d)
+
+			if seconds := last.time.Sub(previous.time).Seconds(); seconds > 0 {
+				rate = strconv.FormatFloat(d/seconds, 'f', 2, 64)
+			}
+		}
+
+		fmt.Fprintf(w, "%-*s  %14s  %12s  %12s  %s\n", width, name, formatValue(last.value), delta, rate, sparkline(points))
+	}
+}
+
+// sparkline draws the values scaled between their minimum and maximum.
+func sparkline(points []topPoint) string {
+
+	min, max := math.Inf(1), math.Inf(-1)
+
+	for _, p := range points {
+		min = math.Min(min, p.value)
+		max = math.Max(max, p.value)
+	}
+
+	var b strings.Builder
+
+	for _, p := range points {
+		bar := 0
+
+		if max > min {
+			bar = int((p.value - min) / (max - min) * float64(len(sparklineBars)-1))
+		}
+
+		b.WriteRune(sparklineBars[bar])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlatten(t *testing.T) {
+
+	var vars map[string]interface{}
+	json.Unmarshal([]byte(`{"foo": {"totalCalls": 5, "averageTime": "1.500", "names": ["a"]}, "cmdline": ["gomon"], "up": 1}`), &vars)
+
+	values := make(map[string]float64)
+	flatten("", vars, values)
+
+	if len(values) != 3 || values["foo.totalCalls"] != 5 || values["foo.averageTime"] != 1.5 || values["up"] != 1 {
+		t.Errorf("Values are different than expected: %v.", values)
+	}
+}
+
+func TestTopView(t *testing.T) {
+
+	view := newTopView(regexp.MustCompile(`^foo\.`))
+	start := time.Unix(0, 0)
+
+	view.update(start, map[string]float64{"foo.totalCalls": 10, "bar.totalCalls": 1})
+	view.update(start.Add(2*time.Second), map[string]float64{"foo.totalCalls": 14, "bar.totalCalls": 2})
+
+	var b bytes.Buffer
+	view.render(&b, 10)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[1]), " ") != "foo.totalCalls 14 4 2.00 ▁█" {
+		t.Errorf("Table is different than expected:\n%s", b.String())
+	}
+}
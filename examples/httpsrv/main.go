@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"github.com/israelchen/gomon/perfcounters"
 	"github.com/israelchen/gomon/telemetry"
 	"golang.org/x/net/context"
 	"log"
@@ -17,11 +18,26 @@ func main() {
 
 	fmtHandler := &telemetry.FmtHandler{}
 
+	// process health counters published next to the business ones
+	perfcounters.NewRuntimeCollector("runtime", 10*time.Second).Start()
+
+	// aggregates latency per operation path across requests, see /debug/telemetry/stats
+	statsHandler := telemetry.NewStatsHandler()
+	http.Handle("/debug/telemetry/stats", statsHandler)
+
+	// lists requests still in flight, see /debug/telemetry
+	tracker := telemetry.NewTracker()
+	http.Handle("/debug/telemetry", tracker)
+
+	// keeps the last finished requests by latency and errors, see /debug/telemetry/recent
+	recentHandler := telemetry.NewRecentHandler(0)
+	http.Handle("/debug/telemetry/recent", recentHandler)
+
 	fooHandler := telemetry.NewPerfHandler("foo")
 
 	http.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
 
-		ctx := telemetry.NewTelemetry(context.Background(), "foo", fmtHandler, fooHandler)
+		ctx := telemetry.NewTelemetry(context.Background(), "foo", fmtHandler, tracker, recentHandler, statsHandler, fooHandler)
 		defer ctx.Close()
 
 		// do some actual work here. Telemetry should be passed just like a regular
@@ -39,7 +55,7 @@ func main() {
 
 	http.HandleFunc("/bar", func(w http.ResponseWriter, r *http.Request) {
 
-		ctx := telemetry.NewTelemetry(context.Background(), "bar", fmtHandler, barHandler)
+		ctx := telemetry.NewTelemetry(context.Background(), "bar", fmtHandler, tracker, recentHandler, statsHandler, barHandler)
 		defer ctx.Close()
 
 		// do some actual work here. Telemetry should be passed just like a regular
@@ -0,0 +1,373 @@
+// Package alert evaluates alerting rules over perfcounters samples inside the process, for
+// deployments without external monitoring. An Engine is a perfcounters.Sink: attach it to a
+// perfcounters.Sampler and its rules are evaluated on every sampler tick.
+//
+// A rule is a condition such as "delta(foo.failedCalls) / delta(foo.totalCalls) > 0.05 for 2m".
+// Series are compared by their sampled values, which for cumulative counters such as totals of
+// calls are lifetime values; delta takes their change over the last sampler tick instead. A rule
+// starts pending when the condition first holds, fires once it held for the duration, and
+// resolves once the value is back on the other side of the clear threshold, which defaults to the
+// threshold, for the clear duration. A clear threshold below a ">" threshold, or above a "<" one,
+// adds hysteresis so that a value hovering around the threshold does not flap.
+//
+// Time is taken from the sample timestamps handed over by the sampler, so the engine can be driven
+// by any clock, e.g. in tests.
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/israelchen/gomon/perfcounters"
+	"github.com/israelchen/gomon/util"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	Inactive State = iota
+	Pending
+	Firing
+)
+
+func (self State) String() string {
+
+	switch self {
+	case Pending:
+		return "pending"
+	case Firing:
+		return "firing"
+	}
+
+	return "inactive"
+}
+
+func (self State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(self.String())
+}
+
+func (self *State) UnmarshalJSON(b []byte) error {
+
+	var s string
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	switch s {
+	case "pending":
+		*self = Pending
+	case "firing":
+		*self = Firing
+	default:
+		*self = Inactive
+	}
+
+	return nil
+}
+
+// Transition describes a rule changing state.
+type Transition struct {
+	Rule  string    `json:"rule"`
+	Expr  string    `json:"expr"`
+	From  State     `json:"from"`
+	To    State     `json:"to"`
+	Value float64   `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+// RuleState is the state of a rule as of the last evaluation.
+type RuleState struct {
+	Name  string    `json:"name"`
+	Expr  string    `json:"expr"`
+	State State     `json:"state"`
+	Value float64   `json:"value"`
+	Since time.Time `json:"since"`
+}
+
+type rule struct {
+	name      string
+	expr      string
+	condition *condition
+	clear     float64
+	clearFor  time.Duration
+	state     State
+	value     float64
+	since     time.Time
+
+	// when the condition, or the clear condition while firing, started holding.
+	holdingSince time.Time
+}
+
+type RuleOption func(r *rule)
+
+// WithClearThreshold sets the value the rule must cross back over before it resolves.
+func WithClearThreshold(threshold float64) RuleOption {
+	return func(r *rule) {
+		r.clear = threshold
+	}
+}
+
+// WithClearFor sets for how long the clear condition must hold before the rule resolves.
+func WithClearFor(d time.Duration) RuleOption {
+	util.Require(d >= 0, "alert: d cannot be negative.")
+
+	return func(r *rule) {
+		r.clearFor = d
+	}
+}
+
+type Engine struct {
+	rules     []*rule
+	callbacks []func(Transition)
+	mu        sync.Mutex
+}
+
+type EngineOption func(e *Engine)
+
+// WithCallback calls fn with every state change. Callbacks run on the sampler goroutine, in the
+// order they were added.
+func WithCallback(fn func(Transition)) EngineOption {
+	util.Require(fn != nil, "alert: fn cannot be nil.")
+
+	return func(e *Engine) {
+		e.callbacks = append(e.callbacks, fn)
+	}
+}
+
+// WithWebhook POSTs every state change as JSON to url, in the background.
+func WithWebhook(url string) EngineOption {
+	util.Require(len(url) > 0, "alert: url cannot be empty.")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return WithCallback(func(transition Transition) {
+		// a missing value is sent as null, JSON having no NaN.
+		payload := struct {
+			Transition
+			Value *float64 `json:"value"`
+		}{Transition: transition}
+
+		if !math.IsNaN(transition.Value) && !math.IsInf(transition.Value, 0) {
+			payload.Value = &transition.Value
+		}
+
+		body, _ := json.Marshal(payload)
+
+		go func() {
+			response, err := client.Post(url, "application/json", bytes.NewReader(body))
+
+			if err != nil {
+				slog.Warn("alert: webhook failed.", "rule", transition.Rule, "error", err)
+				return
+			}
+
+			response.Body.Close()
+
+			if response.StatusCode/100 != 2 {
+				slog.Warn("alert: webhook failed.", "rule", transition.Rule, "status", response.Status)
+			}
+		}()
+	})
+}
+
+func NewEngine(options ...EngineOption) *Engine {
+
+	engine := &Engine{}
+
+	for _, option := range options {
+		option(engine)
+	}
+
+	return engine
+}
+
+// Add adds a rule, returning an error if its expression cannot be parsed.
+func (self *Engine) Add(name string, expr string, options ...RuleOption) error {
+	util.Require(len(name) > 0, "alert: name cannot be empty.")
+
+	condition, err := parse(expr)
+
+	if err != nil {
+		return err
+	}
+
+	r := &rule{
+		name:      name,
+		expr:      expr,
+		condition: condition,
+		clear:     condition.threshold,
+		value:     math.NaN(),
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for _, existing := range self.rules {
+		util.Require(existing.name != name, "alert: rule "+name+" already exists.")
+	}
+
+	self.rules = append(self.rules, r)
+
+	return nil
+}
+
+// Write evaluates every rule against the samples of a tick taken at the given time.
+func (self *Engine) Write(at time.Time, samples []perfcounters.Sample) error {
+
+	values := make(map[string]float64, len(samples))
+
+	for _, sample := range samples {
+		values[sample.Key()] = sample.Value
+	}
+
+	var transitions []Transition
+
+	self.mu.Lock()
+
+	for _, r := range self.rules {
+		if transition, ok := r.evaluate(at, values); ok {
+			transitions = append(transitions, transition)
+		}
+	}
+
+	callbacks := self.callbacks
+
+	self.mu.Unlock()
+
+	for _, transition := range transitions {
+		for _, callback := range callbacks {
+			callback(transition)
+		}
+	}
+
+	return nil
+}
+
+func (self *rule) evaluate(at time.Time, values map[string]float64) (Transition, bool) {
+
+	c := self.condition
+	self.value = c.expr.eval(values)
+	previous := self.state
+
+	switch self.state {
+	case Inactive, Pending:
+		if !compare(self.value, c.op, c.threshold) {
+			self.state = Inactive
+			break
+		}
+
+		if self.state == Inactive {
+			self.state = Pending
+			self.holdingSince = at
+		}
+
+		if at.Sub(self.holdingSince) >= c.duration {
+			self.state = Firing
+			self.holdingSince = time.Time{}
+		}
+
+	case Firing:
+		// missing values neither keep nor clear a firing rule, NaN failing both comparisons.
+		if !compare(self.value, opposite(c.op), self.clear) {
+			self.holdingSince = time.Time{}
+			break
+		}
+
+		if self.holdingSince.IsZero() {
+			self.holdingSince = at
+		}
+
+		if at.Sub(self.holdingSince) >= self.clearFor {
+			self.state = Inactive
+			self.holdingSince = time.Time{}
+		}
+	}
+
+	if self.state == previous {
+		return Transition{}, false
+	}
+
+	self.since = at
+
+	return Transition{
+		Rule:  self.name,
+		Expr:  self.expr,
+		From:  previous,
+		To:    self.state,
+		Value: self.value,
+		At:    at,
+	}, true
+}
+
+// Rules returns the state of the rules, in the order they were added.
+func (self *Engine) Rules() []RuleState {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	states := make([]RuleState, len(self.rules))
+
+	for i, r := range self.rules {
+		states[i] = RuleState{
+			Name:  r.name,
+			Expr:  r.expr,
+			State: r.state,
+			Value: r.value,
+			Since: r.since,
+		}
+	}
+
+	return states
+}
+
+var rulesTemplate = template.Must(template.New("rules").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Alert rules</title></head>
+<body>
+<table border="1" cellpadding="4" style="border-collapse: collapse; font-family: monospace">
+<tr><th>rule</th><th>expression</th><th>state</th><th>value</th><th>since</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Expr}}</td><td>{{.State}}</td><td>{{printf "%g" .Value}}</td><td>{{if not .Since.IsZero}}{{.Since.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// ruleStateRow is a RuleState whose value encodes in JSON even when it is missing.
+type ruleStateRow struct {
+	RuleState
+	Value *float64 `json:"value"`
+}
+
+func (self *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	states := self.Rules()
+
+	if r.FormValue("format") == "json" {
+		rows := make([]ruleStateRow, len(states))
+
+		for i, state := range states {
+			rows[i].RuleState = state
+
+			if !math.IsNaN(state.Value) && !math.IsInf(state.Value, 0) {
+				value := state.Value
+				rows[i].Value = &value
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(rows)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rulesTemplate.Execute(w, states)
+}
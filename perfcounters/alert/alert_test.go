@@ -0,0 +1,200 @@
+package alert
+
+import (
+	"encoding/json"
+	"github.com/israelchen/gomon/perfcounters"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+
+	values := map[string]float64{"foo.failedCalls": 3, "foo.totalCalls": 50, "requests{route=/a}": 4, "queue-depth{route=/a b}": 12}
+
+	tests := []struct {
+		expr     string
+		value    float64
+		op       string
+		duration time.Duration
+	}{
+		{"foo.failedCalls / foo.totalCalls > 0.05 for 2m", 0.06, ">", 2 * time.Minute},
+		{"(foo.failedCalls + 1) * 2 - requests{route=/a} >= 1e1", 4, ">=", 0},
+		{"-foo.failedCalls < -2.5", -3, "<", 0},
+		{"missing / foo.totalCalls != 0", math.NaN(), "!=", 0},
+		{"foo.failedCalls / 0 == 1", math.NaN(), "==", 0},
+		{"`queue-depth{route=/a b}` / `foo.totalCalls`>0.2 for 1m", 0.24, ">", time.Minute},
+	}
+
+	for _, test := range tests {
+		c, err := parse(test.expr)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+
+		value := c.expr.eval(values)
+
+		if (value != test.value && !(math.IsNaN(value) && math.IsNaN(test.value))) || c.op != test.op || c.duration != test.duration {
+			t.Errorf("%s: got %v %s for %v.", test.expr, value, c.op, c.duration)
+		}
+	}
+
+	for _, expr := range []string{"", "foo >", "foo > bar", "(foo > 1", "foo > 1 for", "foo > 1 for 2x", "foo 1", "foo > 1 junk", "`foo > 1", "`` > 1", "rate(foo) > 1", "delta(1) > 1", "delta(foo > 1"} {
+		if _, err := parse(expr); err == nil {
+			t.Errorf("Expected %q to be invalid.", expr)
+		}
+	}
+}
+
+func TestEngine(t *testing.T) {
+
+	var transitions []Transition
+
+	engine := NewEngine(WithCallback(func(transition Transition) {
+		transitions = append(transitions, transition)
+	}))
+
+	err := engine.Add("errorRate", "foo.failedCalls / foo.totalCalls > 0.05 for 2m", WithClearThreshold(0.02), WithClearFor(time.Minute))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0)
+
+	tick := func(minute int, failed float64) {
+		engine.Write(start.Add(time.Duration(minute)*time.Minute), []perfcounters.Sample{
+			{Name: "foo.failedCalls", Value: failed},
+			{Name: "foo.totalCalls", Value: 100},
+		})
+	}
+
+	states := func() []State {
+		var states []State
+
+		for _, transition := range transitions {
+			states = append(states, transition.To)
+		}
+
+		return states
+	}
+
+	tick(0, 10) // pending
+	tick(1, 1)  // back to inactive before firing
+	tick(2, 10) // pending
+	tick(3, 10)
+	tick(4, 10) // firing after 2m
+	tick(5, 4)  // within the hysteresis band, still firing
+	tick(6, 1)  // clear condition starts holding
+	tick(7, 1)  // resolved after 1m
+
+	expected := []State{Pending, Inactive, Pending, Firing, Inactive}
+
+	if got := states(); len(got) != len(expected) {
+		t.Fatalf("Transitions are different than expected: %v.", got)
+	}
+
+	for i, state := range states() {
+		if state != expected[i] {
+			t.Fatalf("Transitions are different than expected: %v.", states())
+		}
+	}
+
+	if transitions[3].Value != 0.1 || !transitions[3].At.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("Firing transition is different than expected: %+v.", transitions[3])
+	}
+
+	// missing samples keep a firing rule firing.
+	tick(8, 10)
+	tick(9, 10)
+	tick(10, 10)
+	engine.Write(start.Add(20*time.Minute), nil)
+
+	if rules := engine.Rules(); len(rules) != 1 || rules[0].State != Firing || !math.IsNaN(rules[0].Value) {
+		t.Errorf("Rules are different than expected: %+v.", rules)
+	}
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest("GET", "/?format=json", nil))
+
+	var rows []map[string]interface{}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &rows); err != nil || len(rows) != 1 || rows[0]["state"] != "firing" || rows[0]["value"] != nil {
+		t.Errorf("JSON is different than expected: %s.", recorder.Body.String())
+	}
+}
+
+func TestDeltaAndMissingValues(t *testing.T) {
+
+	engine := NewEngine()
+	engine.Add("errorRate", "delta(foo.failedCalls) / delta(foo.totalCalls) > 0.05")
+	engine.Add("missing", "missing != 0")
+	engine.Add("equal", "foo.failedCalls == 50")
+
+	start := time.Unix(1700000000, 0)
+
+	tick := func(minute int, failed float64, total float64) map[string]State {
+		engine.Write(start.Add(time.Duration(minute)*time.Minute), []perfcounters.Sample{
+			{Name: "foo.failedCalls", Value: failed},
+			{Name: "foo.totalCalls", Value: total},
+		})
+
+		states := make(map[string]State)
+
+		for _, rule := range engine.Rules() {
+			states[rule.Name] = rule.State
+		}
+
+		return states
+	}
+
+	// the lifetime ratio is 5%, but 10% of the calls of the last tick failed.
+	tick(0, 40, 1000)
+
+	if states := tick(1, 50, 1100); states["errorRate"] != Firing || states["missing"] != Inactive || states["equal"] != Firing {
+		t.Errorf("Expected errorRate and equal to fire, and missing not to, got %v.", states)
+	}
+
+	// missing values resolve no rule, == ones included, and deltas start over after them.
+	if states := tick(2, math.NaN(), math.NaN()); states["errorRate"] != Firing || states["equal"] != Firing {
+		t.Errorf("Expected missing values to keep the rules firing, got %v.", states)
+	}
+
+	if states := tick(3, 51, 2100); states["errorRate"] != Firing {
+		t.Errorf("Expected errorRate to keep firing without a delta, got %v.", states)
+	}
+
+	if states := tick(4, 52, 3100); states["errorRate"] != Inactive {
+		t.Errorf("Expected errorRate to resolve, got %v.", states)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+
+	received := make(chan Transition, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var transition Transition
+		json.NewDecoder(r.Body).Decode(&transition)
+		received <- transition
+	}))
+
+	defer server.Close()
+
+	engine := NewEngine(WithWebhook(server.URL))
+	engine.Add("goroutines", "runtime.goroutines > 1000")
+	engine.Write(time.Now(), []perfcounters.Sample{{Name: "runtime.goroutines", Value: 2000}})
+
+	select {
+	case transition := <-received:
+		if transition.Rule != "goroutines" || transition.To != Firing || transition.Value != 2000 {
+			t.Errorf("Webhook payload is different than expected: %+v.", transition)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook was not called.")
+	}
+}
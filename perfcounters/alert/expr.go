@@ -0,0 +1,414 @@
+package alert
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// node is a parsed arithmetic expression over series values.
+type node interface {
+	eval(values map[string]float64) float64
+}
+
+type number float64
+
+func (self number) eval(values map[string]float64) float64 {
+	return float64(self)
+}
+
+type series string
+
+// eval returns NaN when the series was not sampled, which fails every comparison.
+func (self series) eval(values map[string]float64) float64 {
+
+	if value, ok := values[string(self)]; ok {
+		return value
+	}
+
+	return math.NaN()
+}
+
+// delta is the change of a series since the previous evaluation, for rules over cumulative counters
+// that should only consider the latest tick. It is NaN on the first evaluation and while the series
+// is missing.
+type delta struct {
+	operand series
+	last    float64
+	seen    bool
+}
+
+func (self *delta) eval(values map[string]float64) float64 {
+
+	value := self.operand.eval(values)
+
+	if math.IsNaN(value) {
+		self.seen = false
+		return value
+	}
+
+	last, seen := self.last, self.seen
+	self.last, self.seen = value, true
+
+	if !seen {
+		return math.NaN()
+	}
+
+	return value - last
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+func (self *binary) eval(values map[string]float64) float64 {
+
+	left, right := self.left.eval(values), self.right.eval(values)
+
+	switch self.op {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	}
+
+	if right == 0 {
+		return math.NaN()
+	}
+
+	return left / right
+}
+
+type negate struct {
+	operand node
+}
+
+func (self *negate) eval(values map[string]float64) float64 {
+	return -self.operand.eval(values)
+}
+
+// condition is a parsed rule expression: expr op threshold [for duration].
+type condition struct {
+	expr      node
+	op        string
+	threshold float64
+	duration  time.Duration
+}
+
+// compare returns false for NaN whatever the comparison, so that missing values neither start nor
+// clear a rule.
+func compare(value float64, op string, threshold float64) bool {
+
+	if math.IsNaN(value) {
+		return false
+	}
+
+	switch op {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	}
+
+	return value != threshold
+}
+
+// opposite returns the comparison that holds once a condition with op is clearly over.
+func opposite(op string) string {
+
+	switch op {
+	case ">":
+		return "<="
+	case ">=":
+		return "<"
+	case "<":
+		return ">="
+	case "<=":
+		return ">"
+	case "==":
+		return "!="
+	}
+
+	return "=="
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+// parse parses "expr op threshold [for duration]", where expr is made of series names, numbers,
+// + - * /, parentheses and delta(series), e.g.
+// "delta(foo.failedCalls) / delta(foo.totalCalls) > 0.05 for 2m". Series names
+// are registry names, with labels in braces for the series of vectors, e.g.
+// "requests{route=/foo}". Names with other characters than letters, digits, '_' and '.', or with
+// spaces in label values, are quoted in backticks, e.g. "`queue-depth{route=/a b}` > 10".
+func parse(input string) (*condition, error) {
+
+	p := &parser{input: input}
+	expr, err := p.expr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &condition{expr: expr}
+
+	if c.op = p.comparison(); len(c.op) == 0 {
+		return nil, p.errorf("expected a comparison")
+	}
+
+	threshold, err := p.expr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := threshold.(number); !ok {
+		return nil, p.errorf("expected a number to compare with")
+	}
+
+	c.threshold = float64(threshold.(number))
+
+	p.skipSpaces()
+
+	if strings.HasPrefix(p.input[p.pos:], "for ") {
+		p.pos += len("for ")
+		p.skipSpaces()
+
+		start := p.pos
+
+		for p.pos < len(p.input) && !unicode.IsSpace(rune(p.input[p.pos])) {
+			p.pos += 1
+		}
+
+		if c.duration, err = time.ParseDuration(p.input[start:p.pos]); err != nil || c.duration < 0 {
+			return nil, p.errorf("invalid duration %q", p.input[start:p.pos])
+		}
+	}
+
+	if p.skipSpaces(); p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+
+	return c, nil
+}
+
+func (self *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("alert: %s at offset %d of %q", fmt.Sprintf(format, args...), self.pos, self.input)
+}
+
+func (self *parser) skipSpaces() {
+	for self.pos < len(self.input) && unicode.IsSpace(rune(self.input[self.pos])) {
+		self.pos += 1
+	}
+}
+
+func (self *parser) peek() byte {
+	self.skipSpaces()
+
+	if self.pos < len(self.input) {
+		return self.input[self.pos]
+	}
+
+	return 0
+}
+
+func (self *parser) comparison() string {
+	self.skipSpaces()
+
+	for _, op := range []string{">=", "<=", "==", "!=", ">", "<"} {
+		if strings.HasPrefix(self.input[self.pos:], op) {
+			self.pos += len(op)
+			return op
+		}
+	}
+
+	return ""
+}
+
+// expr parses a sum of terms.
+func (self *parser) expr() (node, error) {
+
+	left, err := self.term()
+
+	for err == nil && (self.peek() == '+' || self.peek() == '-') {
+		op := self.input[self.pos]
+		self.pos += 1
+
+		var right node
+
+		if right, err = self.term(); err == nil {
+			left = &binary{op, left, right}
+		}
+	}
+
+	return left, err
+}
+
+// term parses a product of factors.
+func (self *parser) term() (node, error) {
+
+	left, err := self.factor()
+
+	for err == nil && (self.peek() == '*' || self.peek() == '/') {
+		op := self.input[self.pos]
+		self.pos += 1
+
+		var right node
+
+		if right, err = self.factor(); err == nil {
+			left = &binary{op, left, right}
+		}
+	}
+
+	return left, err
+}
+
+func (self *parser) factor() (node, error) {
+
+	c := self.peek()
+
+	switch {
+	case c == '(':
+		self.pos += 1
+		expr, err := self.expr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if self.peek() != ')' {
+			return nil, self.errorf("expected )")
+		}
+
+		self.pos += 1
+		return expr, nil
+
+	case c == '-':
+		self.pos += 1
+		operand, err := self.factor()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// keep negative numbers as numbers, so that they can be thresholds.
+		if n, ok := operand.(number); ok {
+			return -n, nil
+		}
+
+		return &negate{operand}, nil
+
+	case c >= '0' && c <= '9' || c == '.':
+		start := self.pos
+
+		for self.pos < len(self.input) && strings.IndexByte("0123456789.eE", self.input[self.pos]) >= 0 {
+			// an exponent sign
+			if self.pos > start && (self.input[self.pos] == 'e' || self.input[self.pos] == 'E') && self.pos+1 < len(self.input) && (self.input[self.pos+1] == '-' || self.input[self.pos+1] == '+') {
+				self.pos += 1
+			}
+
+			self.pos += 1
+		}
+
+		value, err := strconv.ParseFloat(self.input[start:self.pos], 64)
+
+		if err != nil {
+			return nil, self.errorf("invalid number %q", self.input[start:self.pos])
+		}
+
+		return number(value), nil
+
+	case c == '`':
+		end := strings.IndexByte(self.input[self.pos+1:], '`')
+
+		if end < 0 {
+			return nil, self.errorf("expected `")
+		}
+
+		if end == 0 {
+			return nil, self.errorf("empty name")
+		}
+
+		name := self.input[self.pos+1 : self.pos+1+end]
+		self.pos += end + 2
+
+		return series(name), nil
+
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := self.pos
+
+		for self.pos < len(self.input) && isNameByte(self.input[self.pos]) {
+			self.pos += 1
+		}
+
+		if self.pos < len(self.input) && self.input[self.pos] == '{' {
+			end := strings.IndexByte(self.input[self.pos:], '}')
+
+			if end < 0 {
+				return nil, self.errorf("expected }")
+			}
+
+			self.pos += end + 1
+		}
+
+		name := self.input[start:self.pos]
+
+		if self.peek() == '(' {
+			return self.function(name)
+		}
+
+		return series(name), nil
+	}
+
+	if c == 0 {
+		return nil, self.errorf("unexpected end")
+	}
+
+	return nil, self.errorf("unexpected %q", c)
+}
+
+// function parses the argument list of a call to name, starting at the opening parenthesis.
+func (self *parser) function(name string) (node, error) {
+
+	if name != "delta" {
+		return nil, self.errorf("unknown function %q", name)
+	}
+
+	self.pos += 1
+	operand, err := self.factor()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := operand.(series)
+
+	if !ok {
+		return nil, self.errorf("expected a series name")
+	}
+
+	if self.peek() != ')' {
+		return nil, self.errorf("expected )")
+	}
+
+	self.pos += 1
+	return &delta{operand: s}, nil
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
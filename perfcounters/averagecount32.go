@@ -21,11 +21,13 @@ Counters of this type include PhysicalDisk\ Avg. Disk Bytes/Transfer.
 */
 
 type AverageCount32 struct {
-	lastCount int32
-	lastBase  int32
-	count     int32
-	base      int32
-	mu        sync.Mutex
+	lastCount   int32
+	lastBase    int32
+	stringCount int32
+	stringBase  int32
+	count       int32
+	base        int32
+	mu          sync.Mutex
 }
 
 func NewAverageCount32() *AverageCount32 {
@@ -52,11 +54,18 @@ func (self *AverageCount32) CalculatedValue() float32 {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
+	return self.calculate(&self.lastCount, &self.lastBase)
+}
+
+// calculate returns the average since the readings at lastCount and lastBase, which it advances.
+// Callers must hold the lock.
+func (self *AverageCount32) calculate(lastCountAt *int32, lastBaseAt *int32) float32 {
+
 	count := self.count
 	base := self.base
 
-	lastCount := self.lastCount
-	lastBase := self.lastBase
+	lastCount := *lastCountAt
+	lastBase := *lastBaseAt
 
 	if base == 0 {
 		return 0
@@ -66,16 +75,19 @@ func (self *AverageCount32) CalculatedValue() float32 {
 		return 0
 	}
 
-	calculatedValue := float32((count - lastCount) / (base - lastBase))
+	calculatedValue := float32(count-lastCount) / float32(base-lastBase)
 
-	self.lastCount = count
-	self.lastBase = base
+	*lastCountAt = count
+	*lastBaseAt = base
 
 	return calculatedValue
 }
 
 func (self *AverageCount32) String() string {
-	return fmt.Sprintf("%.3f", self.CalculatedValue())
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return fmt.Sprintf("%.3f", self.calculate(&self.stringCount, &self.stringBase))
 }
 
 /*
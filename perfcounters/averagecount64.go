@@ -0,0 +1,126 @@
+package perfcounters
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+
+AverageCount64
+
+An average counter that shows how many items are processed, on average, during an operation. Counters of this type display a ratio of the items processed to the number of operations
+completed. The ratio is calculated by comparing the number of items processed during the last interval to the number of operations completed during the last interval.
+Formula: (N 1 -N 0)/(B 1 -B 0), where N 1 and N 0 are performance counter readings, and the B 1 and B 0 are their corresponding AverageBase values. Thus, the numerator represents the
+numbers of items processed during the sample interval, and the denominator represents the number of operations completed during the sample interval.
+
+Counters of this type include PhysicalDisk\ Avg. Disk Bytes/Transfer.
+
+[[source: https://msdn.microsoft.com/en-us/library/system.diagnostics.performancecountertype(v=vs.90).aspx]]
+
+*/
+
+type AverageCount64 struct {
+	lastCount   int64
+	lastBase    int64
+	stringCount int64
+	stringBase  int64
+	count       int64
+	base        int64
+	mu          sync.Mutex
+}
+
+func NewAverageCount64() *AverageCount64 {
+	return &AverageCount64{}
+}
+
+func (self *AverageCount64) Increment() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.count += 1
+	self.base += 1
+}
+
+func (self *AverageCount64) Add(value int64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.count += value
+	self.base += 1
+}
+
+// AddWithBase adds value items processed over base operations, for sources that report both as
+// running totals.
+func (self *AverageCount64) AddWithBase(value int64, base int64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.count += value
+	self.base += base
+}
+
+func (self *AverageCount64) CalculatedValue() float64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.calculate(&self.lastCount, &self.lastBase)
+}
+
+// calculate returns the average since the readings at lastCount and lastBase, which it advances.
+// Callers must hold the lock.
+func (self *AverageCount64) calculate(lastCountAt *int64, lastBaseAt *int64) float64 {
+
+	count := self.count
+	base := self.base
+
+	lastCount := *lastCountAt
+	lastBase := *lastBaseAt
+
+	if base == 0 {
+		return 0
+	}
+
+	if base-lastBase == 0 {
+		return 0
+	}
+
+	calculatedValue := float64(count-lastCount) / float64(base-lastBase)
+
+	*lastCountAt = count
+	*lastBaseAt = base
+
+	return calculatedValue
+}
+
+func (self *AverageCount64) String() string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return fmt.Sprintf("%.3f", self.calculate(&self.stringCount, &self.stringBase))
+}
+
+/*
+
+func main() {
+
+    counter := NewAverageCount64()
+
+    fmt.Println(counter.String()) // should display 0.00
+
+    counter.Increment() // one operation, 1 item
+
+    fmt.Println(counter.String()) // should display 1.00
+
+    counter.Add(9) // one operation, 9 item
+
+    fmt.Println(counter.String()) // should display 9.00
+    fmt.Println(counter.String()) // should display 0.00
+
+    counter.Add(4) // one operation, 4 items
+    counter.Add(8) // one operation, 8 items
+
+    fmt.Println(counter.String()) // should display 6.00
+}
+
+*/
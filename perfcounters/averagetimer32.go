@@ -25,21 +25,17 @@ Counters of this type include PhysicalDisk\ Avg. Disk sec/Transfer.
 type AverageTimer32 struct {
 	lastTime    time.Time
 	lastBase    int32
+	stringTime  time.Time
+	stringBase  int32
 	currentTime time.Time
 	currentBase int32
 	mu          sync.Mutex
 }
 
+// NewAverageTimer32 creates a timer. It only adds up the durations it is given, measured by the
+// caller, so unlike the rate counters it does not read a clock.
 func NewAverageTimer32() *AverageTimer32 {
-
-	now := time.Now()
-
-	return &AverageTimer32{
-		lastTime:    now,
-		lastBase:    0,
-		currentTime: now,
-		currentBase: 0,
-	}
+	return &AverageTimer32{}
 }
 
 func (self *AverageTimer32) Add(duration time.Duration) {
@@ -54,23 +50,33 @@ func (self *AverageTimer32) CalculatedValue() float64 {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
-	lastTime := self.lastTime
+	return self.calculate(&self.lastTime, &self.lastBase)
+}
+
+// calculate returns the average duration in ms since the readings at lastTime and lastBase, which
+// it advances. Callers must hold the lock.
+func (self *AverageTimer32) calculate(lastTimeAt *time.Time, lastBaseAt *int32) float64 {
+
+	lastTime := *lastTimeAt
 	currentTime := self.currentTime
-	lastBase := self.lastBase
+	lastBase := *lastBaseAt
 	currentBase := self.currentBase
 
 	if currentBase == 0 || currentBase-lastBase == 0 {
 		return 0
 	}
 
-	calculatedValue := float64(-(currentTime.Sub(lastTime).Nanoseconds() / 1e6) / int64(currentBase-lastBase))
+	calculatedValue := float64(currentTime.Sub(lastTime)) / float64(time.Millisecond) / float64(currentBase-lastBase)
 
-	self.lastTime = currentTime
-	self.lastBase = currentBase
+	*lastTimeAt = currentTime
+	*lastBaseAt = currentBase
 
 	return calculatedValue
 }
 
 func (self *AverageTimer32) String() string {
-	return fmt.Sprintf("%.3f", self.CalculatedValue())
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return fmt.Sprintf("%.3f", self.calculate(&self.stringTime, &self.stringBase))
 }
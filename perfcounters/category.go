@@ -0,0 +1,311 @@
+package perfcounters
+
+import (
+	"expvar"
+	"github.com/israelchen/gomon/clock"
+	"github.com/israelchen/gomon/util"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+Category
+
+A set of counters declared once and instantiated on demand, one instance per monitored entity such as a downstream host or a tenant, like the Windows
+category -> counter -> instance organisation (e.g. PhysicalDisk\Avg. Disk Bytes/Transfer for instance "0 C:").
+
+Every update to an instance is also applied to the "_Total" instance, which therefore aggregates all instances. When an instance is removed, its
+NumberOfItems64 counters, which are gauges of how many items there are, are subtracted from _Total since those items are gone with it. Rates and
+averages are computed per sample interval, so a removed instance only weighs on _Total for the interval in progress.
+
+*/
+
+// TotalInstance is the name of the instance aggregating all the instances of a category.
+const TotalInstance = "_Total"
+
+// InstanceLabel is the label naming the instance of the samples of a category.
+const InstanceLabel = "instance"
+
+type CounterType int
+
+const (
+	NumberOfItems64Type CounterType = iota
+	RateOfCountsPerSecond32Type
+	CountPerTimeInterval32Type
+	AverageCount64Type
+	AverageTimer32Type
+)
+
+type CounterDefinition struct {
+	Name string
+	Type CounterType
+}
+
+type Category struct {
+	name        string
+	definitions []CounterDefinition
+	instances   map[string]*CategoryInstance
+	total       *CategoryInstance
+	expvar      *expvar.Map
+	clock       clock.Clock
+	mu          sync.RWMutex
+}
+
+type CategoryInstance struct {
+	name     string
+	counters map[string]expvar.Var
+	total    *CategoryInstance
+	clock    clock.Clock
+	lastUsed int64
+	removed  bool
+	mu       sync.Mutex
+}
+
+// NewCategory declares a category of counters, published in expvar under name as a map of
+// instances and registered with DefaultRegistry as "<name>.<counter>" with an InstanceLabel, so
+// exporters see the instance as a label rather than as part of the name.
+func NewCategory(name string, definitions ...CounterDefinition) *Category {
+	return NewCategoryWithClock(clock.Real, name, definitions...)
+}
+
+// NewCategoryWithClock declares a category whose rate counters and idle tracking read the given
+// clock.
+func NewCategoryWithClock(c clock.Clock, name string, definitions ...CounterDefinition) *Category {
+	util.Require(c != nil, "perfcounters: c cannot be nil.")
+	util.Require(len(name) > 0, "perfcounters: name cannot be empty.")
+	util.Require(len(definitions) > 0, "perfcounters: definitions cannot be empty.")
+
+	seen := make(map[string]bool)
+
+	for _, definition := range definitions {
+		util.Require(len(definition.Name) > 0, "perfcounters: counter name cannot be empty.")
+		util.Require(!seen[definition.Name], "perfcounters: counter "+definition.Name+" is declared twice.")
+		util.Require(definition.Type >= NumberOfItems64Type && definition.Type <= AverageTimer32Type, "perfcounters: unknown counter type.")
+
+		seen[definition.Name] = true
+	}
+
+	category := &Category{
+		name:        name,
+		definitions: append([]CounterDefinition(nil), definitions...),
+		instances:   make(map[string]*CategoryInstance),
+		expvar:      expvar.NewMap(name),
+		clock:       c,
+	}
+
+	category.total = category.newInstance(TotalInstance, nil)
+
+	return category
+}
+
+func newCounter(counterType CounterType, c clock.Clock) expvar.Var {
+
+	switch counterType {
+	case RateOfCountsPerSecond32Type:
+		return NewRateOfCountsPerSecond32WithClock(c)
+	case CountPerTimeInterval32Type:
+		return NewCountPerTimeInterval32WithClock(c)
+	case AverageCount64Type:
+		return NewAverageCount64()
+	case AverageTimer32Type:
+		return NewAverageTimer32()
+	}
+
+	return NewNumberOfItems64()
+}
+
+// newInstance creates and publishes an instance. Callers other than NewCategory must hold the lock.
+func (self *Category) newInstance(name string, total *CategoryInstance) *CategoryInstance {
+
+	instance := &CategoryInstance{
+		name:     name,
+		counters: make(map[string]expvar.Var, len(self.definitions)),
+		total:    total,
+		clock:    self.clock,
+		lastUsed: self.clock.Now().UnixNano(),
+	}
+
+	m := new(expvar.Map).Init()
+
+	for _, definition := range self.definitions {
+		counter := newCounter(definition.Type, self.clock)
+
+		instance.counters[definition.Name] = counter
+		m.Set(definition.Name, counter)
+		DefaultRegistry.RegisterWithLabels(self.name+"."+definition.Name, []Label{{Name: InstanceLabel, Value: name}}, counter)
+	}
+
+	self.expvar.Set(name, m)
+
+	return instance
+}
+
+func (self *Category) Name() string {
+	return self.name
+}
+
+// Instance returns the named instance, creating it on first use.
+func (self *Category) Instance(name string) *CategoryInstance {
+	util.Require(len(name) > 0, "perfcounters: name cannot be empty.")
+
+	if name == TotalInstance {
+		return self.total
+	}
+
+	self.mu.RLock()
+	instance, ok := self.instances[name]
+	self.mu.RUnlock()
+
+	if ok {
+		return instance
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if instance, ok := self.instances[name]; ok {
+		return instance
+	}
+
+	instance = self.newInstance(name, self.total)
+	self.instances[name] = instance
+
+	return instance
+}
+
+// Instances returns the names of the instances in order, without the _Total instance.
+func (self *Category) Instances() []string {
+	self.mu.RLock()
+
+	names := make([]string, 0, len(self.instances))
+
+	for name := range self.instances {
+		names = append(names, name)
+	}
+
+	self.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Remove unpublishes an instance and subtracts its gauges from the _Total instance. Later updates
+// through the removed instance are no longer applied to _Total.
+func (self *Category) Remove(name string) {
+	util.Require(name != TotalInstance, "perfcounters: the _Total instance cannot be removed.")
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if instance, ok := self.instances[name]; ok {
+		self.remove(instance)
+	}
+}
+
+// RemoveIdle removes the instances that were not updated for at least idle, and returns their
+// names in order.
+func (self *Category) RemoveIdle(idle time.Duration) []string {
+
+	var removed []string
+
+	// checking and removing under one lock keeps Instance from handing out an instance in between
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	now := self.clock.Now()
+
+	for name, instance := range self.instances {
+		if now.Sub(instance.LastUsed()) >= idle {
+			self.remove(instance)
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Strings(removed)
+	return removed
+}
+
+// remove unpublishes an instance. Callers must hold the lock.
+func (self *Category) remove(instance *CategoryInstance) {
+
+	delete(self.instances, instance.name)
+	self.expvar.Delete(instance.name)
+
+	// the instance lock keeps an Add from updating the gauges between their subtraction and the
+	// removed mark, which would leave its value in _Total
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+
+	instance.removed = true
+
+	for _, definition := range self.definitions {
+		DefaultRegistry.Unregister(Sample{Name: self.name + "." + definition.Name, Labels: []Label{{Name: InstanceLabel, Value: instance.name}}}.Key())
+
+		if gauge, ok := instance.counters[definition.Name].(*NumberOfItems64); ok {
+			self.total.counters[definition.Name].(*NumberOfItems64).Add(-gauge.Value())
+		}
+	}
+}
+
+func (self *CategoryInstance) Name() string {
+	return self.name
+}
+
+// Counter returns the named counter of the instance, or nil if the category does not declare it.
+func (self *CategoryInstance) Counter(name string) expvar.Var {
+	return self.counters[name]
+}
+
+// LastUsed returns when the instance was created or last updated.
+func (self *CategoryInstance) LastUsed() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&self.lastUsed))
+}
+
+func (self *CategoryInstance) Increment(counter string) {
+	self.Add(counter, 1)
+}
+
+// Add adds value to a counter of the instance and of the _Total instance. For average counters it
+// is one operation processing value items, for timers a duration in nanoseconds. Values added to
+// the 32-bit rate counters must fit in an int32.
+func (self *CategoryInstance) Add(counter string, value int64) {
+
+	v, ok := self.counters[counter]
+	util.Require(ok, "perfcounters: unknown counter "+counter+".")
+
+	switch v.(type) {
+	case *RateOfCountsPerSecond32, *CountPerTimeInterval32:
+		util.Require(value >= math.MinInt32 && value <= math.MaxInt32, "perfcounters: value overflows counter "+counter+".")
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	switch c := v.(type) {
+	case *NumberOfItems64:
+		c.Add(value)
+	case *RateOfCountsPerSecond32:
+		c.Add(int32(value))
+	case *CountPerTimeInterval32:
+		c.Add(int32(value))
+	case *AverageCount64:
+		c.Add(value)
+	case *AverageTimer32:
+		c.Add(time.Duration(value))
+	}
+
+	atomic.StoreInt64(&self.lastUsed, self.clock.Now().UnixNano())
+
+	if self.total != nil && !self.removed {
+		self.total.Add(counter, value)
+	}
+}
+
+// AddDuration adds an operation that took d to a timer of the instance and of the _Total instance.
+func (self *CategoryInstance) AddDuration(counter string, d time.Duration) {
+	self.Add(counter, int64(d))
+}
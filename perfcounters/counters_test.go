@@ -1,7 +1,13 @@
 package perfcounters
 
 import (
+	"expvar"
+	"github.com/israelchen/gomon/clock"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNumberOfItems32(t *testing.T) {
@@ -39,13 +45,504 @@ func TestNumberOfItems64(t *testing.T) {
 }
 
 func TestAverageCount32(t *testing.T) {
+
+	counter := NewAverageCount32()
+
+	if counter.String() != "0.000" {
+		t.Error("Expected 0 before any operation.")
+	}
+
+	counter.Add(4)
+	counter.Add(7)
+
+	if value := counter.CalculatedValue(); value != 5.5 {
+		t.Errorf("Expected an average of 5.5 items, got %v.", value)
+	}
+
+	if value := counter.CalculatedValue(); value != 0 {
+		t.Errorf("Expected 0 without operations during the interval, got %v.", value)
+	}
+
+	counter.Increment()
+
+	if value := counter.CalculatedValue(); value != 1 {
+		t.Errorf("Expected an average of 1 item, got %v.", value)
+	}
 }
 
 func TestAverageTimer32(t *testing.T) {
+
+	counter := NewAverageTimer32()
+
+	counter.Add(10 * time.Millisecond)
+	counter.Add(25 * time.Millisecond)
+
+	if value := counter.CalculatedValue(); value != 17.5 {
+		t.Errorf("Expected an average of 17.5ms, got %v.", value)
+	}
+
+	if value := counter.CalculatedValue(); value != 0 {
+		t.Errorf("Expected 0 without operations during the interval, got %v.", value)
+	}
+
+	counter.Add(time.Second)
+
+	// String keeps its own interval, e.g. for /debug/vars, without disturbing CalculatedValue.
+	if counter.String() != "345.000" {
+		t.Errorf("Expected an average of 345ms since String was first read, got %s.", counter)
+	}
+
+	if value := counter.CalculatedValue(); value != 1000 {
+		t.Errorf("Expected an average of 1000ms, got %v.", value)
+	}
 }
 
 func TestRateOfCountsPerSecond32(t *testing.T) {
+
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	counter := NewRateOfCountsPerSecond32WithClock(c)
+
+	if value := counter.CalculatedValue(); value != 0 {
+		t.Errorf("Expected 0 before any count, got %v.", value)
+	}
+
+	counter.Increment()
+	c.Advance(time.Second)
+
+	if value := counter.CalculatedValue(); value != 1 {
+		t.Errorf("Expected 1 per second, got %v.", value)
+	}
+
+	counter.Add(3)
+	c.Advance(2 * time.Second)
+
+	if value := counter.CalculatedValue(); value != 1.5 {
+		t.Errorf("Expected 1.5 per second, got %v.", value)
+	}
+
+	c.Advance(time.Second)
+
+	if value := counter.CalculatedValue(); value != 0 {
+		t.Errorf("Expected 0 per second, got %v.", value)
+	}
+
+	// no time elapsed
+	counter.Increment()
+
+	if value := counter.CalculatedValue(); value != 0 {
+		t.Errorf("Expected 0 when no time elapsed, got %v.", value)
+	}
+
+	// String has its own interval, started by the first count.
+	if counter.String() != "1.250" {
+		t.Errorf("Expected 1.25 per second since the first count, got %s.", counter)
+	}
+}
+
+func TestRateOfCountsPerSecond64(t *testing.T) {
+
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	counter := NewRateOfCountsPerSecond64WithClock(c)
+
+	// more than an int32 can hold in a single interval, e.g. bytes of a 10GbE link over 10s
+	counter.Add(12500000000)
+	c.Advance(10 * time.Second)
+
+	if value := counter.CalculatedValue(); value != 1250000000 {
+		t.Errorf("Expected 1250000000 per second, got %v.", value)
+	}
 }
 
 func TestCountPerItemInterval32(t *testing.T) {
+
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	counter := NewCountPerTimeInterval32WithClock(c)
+
+	counter.Add(10)
+	c.Advance(4 * time.Millisecond)
+
+	if value := counter.CalculatedValue(); value != 2.5 {
+		t.Errorf("Expected 2.5 per millisecond, got %v.", value)
+	}
+
+	counter.Add(-2)
+	c.Advance(time.Second)
+
+	if value := counter.CalculatedValue(); value != -0.002 {
+		t.Errorf("Expected -0.002 per millisecond, got %v.", value)
+	}
+
+	// used to divide by zero
+	counter.Increment()
+
+	if value := counter.CalculatedValue(); value != 0 {
+		t.Errorf("Expected 0 when no time elapsed, got %v.", value)
+	}
+}
+
+func TestAverageCount64(t *testing.T) {
+
+	counter := NewAverageCount64()
+
+	if counter.String() != "0.000" {
+		t.Error("Expected average of 0.")
+	}
+
+	counter.Add(9)
+
+	if counter.String() != "9.000" {
+		t.Error("Expected average of 9.")
+	}
+
+	counter.Add(4)
+	counter.Add(7)
+
+	if counter.String() != "5.500" {
+		t.Error("Expected average of 5.5.")
+	}
+
+	if counter.String() != "0.000" {
+		t.Error("Expected average of 0 for an empty interval.")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+
+	h := NewHistogram(10, 20, 50)
+
+	for _, value := range []float64{1, 5, 10, 12, 15, 18, 30, 40, 45, 100} {
+		h.Add(value)
+	}
+
+	if h.Count() != 10 || h.Sum() != 276 {
+		t.Errorf("Expected 10 values summing to 276, got %d and %v.", h.Count(), h.Sum())
+	}
+
+	// 3 values up to 10, 3 up to 20, 3 up to 50 and 1 overflow.
+	_, counts := h.Buckets()
+
+	if len(counts) != 4 || counts[0] != 3 || counts[1] != 3 || counts[2] != 3 || counts[3] != 1 {
+		t.Errorf("Bucket counts are different than expected: %v.", counts)
+	}
+
+	if q := h.Quantile(0.5); q < 10 || q > 20 {
+		t.Errorf("Expected median between 10 and 20, got %v.", q)
+	}
+
+	if h.Quantile(0) != 1 || h.Quantile(1) != 100 {
+		t.Errorf("Expected quantiles 0 and 1 to be the min and max, got %v and %v.", h.Quantile(0), h.Quantile(1))
+	}
+}
+
+func TestRegistrySample(t *testing.T) {
+
+	registry := NewRegistry()
+
+	items := NewNumberOfItems32()
+	items.Add(3)
+
+	average := NewAverageCount64()
+	average.Add(4)
+	average.Add(6)
+
+	registry.Register("test.items", items)
+	registry.Register("test.average", average)
+	registry.Register("test.text", expvar.Func(func() interface{} { return "text" }))
+
+	samples := registry.Sample()
+
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 numeric samples, got %d.", len(samples))
+	}
+
+	if samples[0].Name != "test.average" || samples[0].Value != 5 || samples[1].Name != "test.items" || samples[1].Value != 3 {
+		t.Errorf("Samples are different than expected: %+v.", samples)
+	}
+}
+
+func TestRuntimeCollector(t *testing.T) {
+
+	collector := NewRuntimeCollector("test.runtime", time.Hour)
+
+	runtime.GC()
+	collector.Collect()
+
+	if collector.counters["goroutines"].Value() < 1 {
+		t.Error("Expected at least one goroutine.")
+	}
+
+	if collector.counters["heapInUseBytes"].Value() <= 0 {
+		t.Error("Expected heap in use.")
+	}
+
+	if DefaultRegistry.Get("test.runtime.gcPauseP99Micros") == nil {
+		t.Error("GC pause quantiles were not registered.")
+	}
+
+	if runtime.GOOS == "linux" && collector.counters["openFDs"].Value() < 3 {
+		t.Error("Expected at least stdin, stdout and stderr to be open.")
+	}
+}
+
+func TestRuntimeCollectorCollectsOnItsClock(t *testing.T) {
+
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	collector := NewRuntimeCollectorWithClock(c, "test.runtime.clock", time.Minute)
+	goroutines := collector.counters["goroutines"]
+
+	collector.Start()
+	goroutines.Set(0)
+	c.Advance(time.Minute)
+
+	if goroutines.Value() < 1 {
+		t.Error("Expected a collection after an interval.")
+	}
+
+	collector.Stop()
+	goroutines.Set(0)
+	c.Advance(time.Minute)
+
+	if goroutines.Value() != 0 {
+		t.Error("Expected no collection once stopped.")
+	}
+}
+
+func TestCategory(t *testing.T) {
+
+	c := clock.NewFake(time.Unix(1700000000, 0))
+
+	category := NewCategoryWithClock(c, "test.category",
+		CounterDefinition{"requests", NumberOfItems64Type},
+		CounterDefinition{"latency", AverageTimer32Type},
+	)
+
+	category.Instance("host-a").Increment("requests")
+	category.Instance("host-a").AddDuration("latency", 10*time.Millisecond)
+	category.Instance("host-b").Add("requests", 2)
+	category.Instance("host-b").AddDuration("latency", 30*time.Millisecond)
+
+	if names := category.Instances(); len(names) != 2 || names[0] != "host-a" || names[1] != "host-b" {
+		t.Fatalf("Instances are different than expected: %v.", names)
+	}
+
+	total := category.Instance(TotalInstance)
+
+	if total.Counter("requests").String() != "3" || total.Counter("latency").String() != "20.000" {
+		t.Errorf("_Total is different than expected: %s requests, %s latency.", total.Counter("requests"), total.Counter("latency"))
+	}
+
+	if DefaultRegistry.Get("test.category.requests{instance=host-b}") == nil {
+		t.Error("Instance counters were not registered.")
+	}
+
+	for _, sample := range DefaultRegistry.Sample() {
+		if sample.Name == "test.category.requests" && (len(sample.Labels) != 1 || sample.Labels[0].Name != InstanceLabel) {
+			t.Errorf("Expected the instance as a label, got %v.", sample.Labels)
+		}
+	}
+
+	stale := category.Instance("host-a")
+
+	c.Advance(time.Hour)
+	category.Instance("host-b").Increment("requests")
+
+	if removed := category.RemoveIdle(time.Minute); len(removed) != 1 || removed[0] != "host-a" {
+		t.Fatalf("Expected host-a to be removed, got %v.", removed)
+	}
+
+	if DefaultRegistry.Get("test.category.requests{instance=host-a}") != nil || category.expvar.Get("host-a") != nil {
+		t.Error("host-a was not unpublished.")
+	}
+
+	// gauges of removed instances are subtracted from _Total, and the instances start over when used again.
+	stale.Increment("requests")
+
+	if total.Counter("requests").String() != "3" {
+		t.Errorf("host-a was not subtracted from _Total: %s.", total.Counter("requests"))
+	}
+
+	category.Instance("host-a").Increment("requests")
+
+	if category.Instance("host-a").Counter("requests").String() != "1" || total.Counter("requests").String() != "4" {
+		t.Error("host-a did not start over.")
+	}
+}
+
+func TestCategoryRemoveWhileAdding(t *testing.T) {
+
+	category := NewCategory("test.categoryChurn", CounterDefinition{"inFlight", NumberOfItems64Type})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				category.Instance("host").Increment("inFlight")
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		category.Remove("host")
+	}
+
+	wg.Wait()
+	category.Remove("host")
+
+	// every increment is either still counted by an instance or was subtracted with it
+	if value := category.Instance(TotalInstance).Counter("inFlight").(*NumberOfItems64).Value(); value != 0 {
+		t.Errorf("Expected _Total to drop to 0 with the instances, got %d.", value)
+	}
+}
+
+func TestCounterVec(t *testing.T) {
+
+	v := NewCounterVec("test.counterVec", []string{"route", "status"}, WithMaxSeries(2), WithExpiry(2))
+
+	v.Add(2, "/a", "200")
+	v.Increment("/a", "500")
+	v.Increment("/b", "200")
+	v.Increment("/c", "200")
+
+	if v.Len() != 3 {
+		t.Fatalf("Expected 2 series and the overflow series, got %d.", v.Len())
+	}
+
+	if s := v.String(); s != `{"route=/a,status=200":2,"route=/a,status=500":1,"route=__overflow__,status=__overflow__":2}` {
+		t.Errorf("String is different than expected: %s.", s)
+	}
+
+	var samples []Sample
+
+	for _, sample := range DefaultRegistry.Sample() {
+		if sample.Name == "test.counterVec" {
+			samples = append(samples, sample)
+		}
+	}
+
+	if len(samples) != 3 || samples[0].Labels[0] != (Label{"route", "/a"}) || samples[0].Labels[1] != (Label{"status", "200"}) || samples[0].Value != 2 {
+		t.Fatalf("Samples are different than expected: %v.", samples)
+	}
+
+	// only /a 200 is used from now on, the other series expire after two intervals.
+	v.Increment("/a", "200")
+	v.Collect("test.counterVec")
+	v.Increment("/a", "200")
+	v.Collect("test.counterVec")
+
+	if v.Len() != 1 || !strings.Contains(v.String(), `"route=/a,status=200":4`) {
+		t.Errorf("Unused series did not expire: %s.", v)
+	}
+
+	// series whose counter was handed out never expire, even when not written to.
+	kept := v.WithLabelValues("/k", "200")
+	kept.Increment()
+
+	for i := 0; i < 4; i++ {
+		v.Collect("test.counterVec")
+	}
+
+	if v.Len() != 1 || !strings.Contains(v.String(), `"route=/k,status=200":1`) {
+		t.Errorf("Kept series expired: %s.", v)
+	}
+
+	gauges := NewGaugeVec("test.gaugeVec.kept", []string{"pool"}, WithExpiry(1))
+	gauge := gauges.WithLabelValues("a,b=c")
+	gauge.Set(7)
+	gauges.Set(3, "idle")
+
+	for i := 0; i < 3; i++ {
+		gauges.Collect("test.gaugeVec.kept")
+	}
+
+	// label values are escaped in expvar keys.
+	if s := gauges.String(); s != `{"pool=a\\,b\\=c":7}` {
+		t.Errorf("Expected only the kept gauge, with an escaped key, got %s.", s)
+	}
+
+	timers := NewTimerVec("test.timerVec.expiry", []string{"tenant"}, WithExpiry(1))
+	timers.Observe(time.Millisecond, "x")
+	timers.Collect("test.timerVec.expiry")
+	timers.Collect("test.timerVec.expiry")
+
+	if timers.Len() != 0 {
+		t.Error("Observed timer series did not expire.")
+	}
+}
+
+func TestVecOption(t *testing.T) {
+
+	withoutLimit := func(o *VecOptions) {
+		o.MaxSeries = 1 << 30
+	}
+
+	v := NewCounterVec("test.counterVec.option", []string{"route"}, WithMaxSeries(1), withoutLimit)
+
+	v.Increment("/a")
+	v.Increment("/b")
+
+	if v.Len() != 2 {
+		t.Errorf("Expected the option to lift the limit, got %d series.", v.Len())
+	}
+}
+
+func TestTimerVec(t *testing.T) {
+
+	v := NewTimerVec("test.timerVec", []string{"tenant"})
+
+	v.Observe(10*time.Millisecond, "x")
+	v.Observe(30*time.Millisecond, "x")
+
+	if s := v.String(); s != `{"tenant=x":20.000}` {
+		t.Errorf("String is different than expected: %s.", s)
+	}
+}
+
+type recordingSink struct {
+	at      []time.Time
+	samples [][]Sample
+}
+
+func (self *recordingSink) Write(at time.Time, samples []Sample) error {
+	self.at = append(self.at, at)
+	self.samples = append(self.samples, samples)
+	return nil
+}
+
+func TestSampler(t *testing.T) {
+
+	registry := NewRegistry()
+	counter := NewNumberOfItems64()
+	registry.Register("counter", counter)
+
+	sink := &recordingSink{}
+	sampler := NewSampler(registry, time.Hour, WithSink(sink))
+
+	counter.Add(3)
+	sampler.Tick(time.Now())
+
+	if len(sink.samples) != 1 || len(sink.samples[0]) != 1 || sink.samples[0][0].Value != 3 {
+		t.Errorf("Samples are different than expected: %v.", sink.samples)
+	}
+}
+
+func TestSamplerTicksOnItsClock(t *testing.T) {
+
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	sink := &recordingSink{}
+	sampler := NewSampler(NewRegistry(), time.Minute, WithSink(sink), WithSamplerClock(c))
+
+	sampler.Start()
+	c.Advance(time.Minute)
+	c.Advance(time.Minute)
+	sampler.Stop()
+	c.Advance(time.Minute)
+
+	if len(sink.at) != 2 || !sink.at[1].Equal(time.Unix(1700000120, 0)) {
+		t.Errorf("Expected 2 ticks stamped with the clock, got %v.", sink.at)
+	}
 }
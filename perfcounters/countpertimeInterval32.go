@@ -2,6 +2,8 @@ package perfcounters
 
 import (
 	"fmt"
+	"github.com/israelchen/gomon/clock"
+	"github.com/israelchen/gomon/util"
 	"sync"
 	"time"
 )
@@ -13,6 +15,7 @@ CountPerTimeInterval32
 An average counter designed to monitor the average length of a queue to a resource over time. It shows the difference between the queue lengths observed during the last two sample
 intervals divided by the duration of the interval. This type of counter is typically used to track the number of items that are queued or waiting.
 Formula: (N 1 - N 0) / (D 1 - D 0), where the numerator represents the number of items in the queue and the denominator represents the time elapsed during the last sample interval.
+The elapsed time is measured in milliseconds.
 
 [[source: https://msdn.microsoft.com/en-us/library/system.diagnostics.performancecountertype(v=vs.90).aspx]]
 
@@ -21,16 +24,26 @@ Formula: (N 1 - N 0) / (D 1 - D 0), where the numerator represents the number of
 type CountPerTimeInterval32 struct {
 	lastCount    int32
 	lastTime     *time.Time
+	stringCount  int32
+	stringTime   *time.Time
 	currentCount int32
+	clock        clock.Clock
 	mu           sync.Mutex
 }
 
 func NewCountPerTimeInterval32() *CountPerTimeInterval32 {
+	return NewCountPerTimeInterval32WithClock(clock.Real)
+}
+
+// NewCountPerTimeInterval32WithClock creates a counter measuring its intervals with c.
+func NewCountPerTimeInterval32WithClock(c clock.Clock) *CountPerTimeInterval32 {
+	util.Require(c != nil, "perfcounters: c cannot be nil.")
 
 	return &CountPerTimeInterval32{
 		lastTime:     nil,
 		lastCount:    0,
 		currentCount: 0,
+		clock:        c,
 	}
 }
 
@@ -45,34 +58,55 @@ func (self *CountPerTimeInterval32) Add(value int32) {
 	self.currentCount += value
 
 	if self.lastTime == nil {
-		now := time.Now()
+		now := self.clock.Now()
 		self.lastTime = &now
 	}
+
+	if self.stringTime == nil {
+		now := self.clock.Now()
+		self.stringTime = &now
+	}
 }
 
 func (self *CountPerTimeInterval32) CalculatedValue() float64 {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
-	currentTime := time.Now()
+	return self.calculate(&self.lastTime, &self.lastCount)
+}
 
-	if self.lastTime == nil {
-		self.lastTime = &currentTime
+// calculate returns the count per ms since the readings at lastTimeAt and lastCountAt, which it
+// advances. Callers must hold the lock.
+func (self *CountPerTimeInterval32) calculate(lastTimeAt **time.Time, lastCountAt *int32) float64 {
+
+	currentTime := self.clock.Now()
+
+	if *lastTimeAt == nil {
+		*lastTimeAt = &currentTime
 		return 0
 	}
 
-	lastTime := *self.lastTime
-	lastCount := self.lastCount
+	lastTime := **lastTimeAt
+	lastCount := *lastCountAt
 	currentCount := self.currentCount
 
-	calculatedValue := float64(int64(currentCount-lastCount) / (currentTime.Sub(lastTime).Nanoseconds() / 1e6))
+	elapsed := float64(currentTime.Sub(lastTime)) / float64(time.Millisecond)
 
-	self.lastTime = &currentTime
-	self.lastCount = currentCount
+	if elapsed <= 0 {
+		return 0
+	}
+
+	calculatedValue := float64(currentCount-lastCount) / elapsed
+
+	*lastTimeAt = &currentTime
+	*lastCountAt = currentCount
 
 	return calculatedValue
 }
 
 func (self *CountPerTimeInterval32) String() string {
-	return fmt.Sprintf("%.3f", self.CalculatedValue())
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return fmt.Sprintf("%.3f", self.calculate(&self.stringTime, &self.stringCount))
 }
@@ -0,0 +1,180 @@
+// Package graphite pushes perfcounters samples to Graphite using the plaintext protocol over TCP.
+// An Exporter is a perfcounters.Sink; attach it to a perfcounters.Sampler to push every registered
+// counter on each sampler tick.
+package graphite
+
+import (
+	"bytes"
+	"github.com/israelchen/gomon/perfcounters"
+	"github.com/israelchen/gomon/perfcounters/internal/push"
+	"github.com/israelchen/gomon/util"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxBatches  = 60
+	defaultMinBackoff  = time.Second
+	defaultMaxBackoff  = 5 * time.Minute
+	defaultDialTimeout = 5 * time.Second
+)
+
+// Exporter writes each sampler tick as one batch of "path value timestamp" lines. Counter names
+// are used as paths, and labels of counter vectors as Graphite tags, e.g.
+// "myservice.requests;route=/foo 3 1700000000".
+//
+// Batches that cannot be sent are kept, up to a maximum, and retried with exponential backoff on
+// the following ticks, reconnecting as needed. When a connection fails in the middle of a batch,
+// only the lines that were not completely written are retried.
+type Exporter struct {
+	address    string
+	prefix     string
+	timeout    time.Duration
+	maxBatches int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	conn       net.Conn
+	queue      *push.Queue
+	mu         sync.Mutex
+}
+
+type Option func(e *Exporter)
+
+// WithPrefix prepends prefix to every path, e.g. "myservice.".
+func WithPrefix(prefix string) Option {
+	return func(e *Exporter) {
+		e.prefix = prefix
+	}
+}
+
+// WithMaxBatches sets how many ticks are kept while Graphite cannot be reached. Defaults to 60.
+func WithMaxBatches(max int) Option {
+	util.Require(max > 0, "graphite: max must be positive.")
+
+	return func(e *Exporter) {
+		e.maxBatches = max
+	}
+}
+
+// WithBackoff sets the backoff of retries, see push.NewQueue. Defaults to 1s and 5m.
+func WithBackoff(min time.Duration, max time.Duration) Option {
+	util.Require(min > 0 && max >= min, "graphite: invalid backoff.")
+
+	return func(e *Exporter) {
+		e.minBackoff = min
+		e.maxBackoff = max
+	}
+}
+
+// NewExporter creates an exporter sending to the Graphite server at address, e.g.
+// "localhost:2003". The connection is made on the first write.
+func NewExporter(address string, options ...Option) *Exporter {
+	util.Require(len(address) > 0, "graphite: address cannot be empty.")
+
+	exporter := &Exporter{
+		address:    address,
+		timeout:    defaultDialTimeout,
+		maxBatches: defaultMaxBatches,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+
+	for _, option := range options {
+		option(exporter)
+	}
+
+	exporter.queue = push.NewQueue(exporter.send, exporter.maxBatches, exporter.minBackoff, exporter.maxBackoff)
+
+	return exporter
+}
+
+// Write formats the samples as lines and pushes them as one batch.
+func (self *Exporter) Write(at time.Time, samples []perfcounters.Sample) error {
+
+	var batch bytes.Buffer
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	for _, sample := range samples {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+
+		batch.WriteString(self.prefix)
+		batch.WriteString(sanitize(sample.Name))
+
+		for _, label := range sample.Labels {
+			batch.WriteString(";" + sanitize(label.Name) + "=" + sanitize(label.Value))
+		}
+
+		batch.WriteString(" " + strconv.FormatFloat(sample.Value, 'f', -1, 64) + " " + timestamp + "\n")
+	}
+
+	return self.queue.Push(at, batch.Bytes())
+}
+
+// Pending returns the number of batches waiting to be sent, including a partly sent one.
+func (self *Exporter) Pending() int {
+	return self.queue.Len()
+}
+
+// Dropped returns the number of batches dropped because too many were waiting to be sent.
+func (self *Exporter) Dropped() int64 {
+	return self.queue.Dropped()
+}
+
+// send writes the batch, returning the length of the lines completely written when failing. A
+// line cut by the failure is sent again whole, the cut one being discarded by Graphite as invalid.
+func (self *Exporter) send(batch []byte) (int, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.conn == nil {
+		conn, err := net.DialTimeout("tcp", self.address, self.timeout)
+
+		if err != nil {
+			return 0, err
+		}
+
+		self.conn = conn
+	}
+
+	self.conn.SetWriteDeadline(time.Now().Add(self.timeout))
+
+	if n, err := self.conn.Write(batch); err != nil {
+		self.conn.Close()
+		self.conn = nil
+		return bytes.LastIndexByte(batch[:n], '\n') + 1, err
+	}
+
+	return len(batch), nil
+}
+
+func (self *Exporter) Close() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.conn == nil {
+		return nil
+	}
+
+	err := self.conn.Close()
+	self.conn = nil
+
+	return err
+}
+
+// sanitize replaces the characters that delimit the parts of a line or a tag.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', ';', '=', '~', '!', '^':
+			return '_'
+		}
+
+		return r
+	}, s)
+}
@@ -0,0 +1,137 @@
+package graphite
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/israelchen/gomon/perfcounters"
+	"net"
+	"testing"
+	"time"
+)
+
+// brokenConn accepts up to limit bytes, then fails.
+type brokenConn struct {
+	net.Conn
+	written bytes.Buffer
+	limit   int
+}
+
+func (self *brokenConn) Write(b []byte) (int, error) {
+
+	if self.written.Len()+len(b) <= self.limit {
+		return self.written.Write(b)
+	}
+
+	n, _ := self.written.Write(b[:self.limit-self.written.Len()])
+	return n, errors.New("broken pipe")
+}
+
+func (self *brokenConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (self *brokenConn) Close() error {
+	return nil
+}
+
+func TestExporterRetriesUntilReachable(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := listener.Addr().String()
+	listener.Close()
+
+	exporter := NewExporter(address, WithPrefix("svc."), WithBackoff(time.Second, time.Minute))
+	defer exporter.Close()
+
+	start := time.Unix(1700000000, 0)
+	samples := []perfcounters.Sample{
+		{Name: "foo.totalCalls", Value: 5},
+		{Name: "requests", Labels: []perfcounters.Label{{Name: "route", Value: "/a b"}}, Value: 1.5},
+	}
+
+	if err := exporter.Write(start, samples); err == nil || exporter.Pending() != 1 {
+		t.Fatalf("Expected the batch to be kept after failing, got %v and %d pending.", err, exporter.Pending())
+	}
+
+	listener, err = net.Listen("tcp", address)
+
+	if err != nil {
+		t.Skip("Could not listen again on", address, err)
+	}
+
+	defer listener.Close()
+
+	// still backing off.
+	if err := exporter.Write(start.Add(500*time.Millisecond), samples[:1]); err != nil || exporter.Pending() != 2 {
+		t.Fatalf("Expected no attempt while backing off, got %v and %d pending.", err, exporter.Pending())
+	}
+
+	if err := exporter.Write(start.Add(2*time.Second), nil); err != nil || exporter.Pending() != 0 {
+		t.Fatalf("Expected the batches to be sent, got %v and %d pending.", err, exporter.Pending())
+	}
+
+	conn, err := listener.Accept()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	expected := []string{
+		"svc.foo.totalCalls 5 1700000000\n",
+		"svc.requests;route=/a_b 1.5 1700000000\n",
+		"svc.foo.totalCalls 5 1700000000\n",
+	}
+
+	for _, line := range expected {
+		received, err := reader.ReadString('\n')
+
+		if err != nil || received != line {
+			t.Fatalf("Expected %q, got %q (%v).", line, received, err)
+		}
+	}
+}
+
+func TestExporterRetriesOnlyUnwrittenLines(t *testing.T) {
+
+	exporter := NewExporter("127.0.0.1:1", WithBackoff(time.Second, time.Minute))
+	defer exporter.Close()
+
+	// the connection fails in the middle of the second line.
+	conn := &brokenConn{limit: 30}
+	exporter.conn = conn
+
+	start := time.Unix(1700000000, 0)
+	samples := []perfcounters.Sample{
+		{Name: "foo.totalCalls", Value: 5},
+		{Name: "foo.failedCalls", Value: 1},
+		{Name: "foo.queue", Value: 2},
+	}
+
+	if err := exporter.Write(start, samples); err == nil || exporter.Pending() != 1 {
+		t.Fatalf("Expected the batch to be kept after failing, got %v and %d pending.", err, exporter.Pending())
+	}
+
+	retry := &brokenConn{limit: 1 << 20}
+	exporter.conn = retry
+
+	if err := exporter.Write(start.Add(2*time.Second), nil); err != nil || exporter.Pending() != 0 {
+		t.Fatalf("Expected the rest of the batch to be sent, got %v and %d pending.", err, exporter.Pending())
+	}
+
+	if conn.written.String() != "foo.totalCalls 5 1700000000\nfo" {
+		t.Errorf("Unexpected first write: %q.", conn.written.String())
+	}
+
+	if retry.written.String() != "foo.failedCalls 1 1700000000\nfoo.queue 2 1700000000\n" {
+		t.Errorf("Expected only the unwritten lines to be retried, got %q.", retry.written.String())
+	}
+}
@@ -0,0 +1,150 @@
+package perfcounters
+
+import (
+	"encoding/json"
+	"github.com/israelchen/gomon/util"
+	"math"
+	"sort"
+	"sync"
+)
+
+/*
+
+Histogram
+
+A distribution counter that records how many observed values fall into each of a fixed set of buckets. Unlike the average counters, it keeps the shape of the distribution so that
+quantiles (median, 90th percentile, ...) can be estimated, which is what latency is usually judged by.
+Formula: the q-quantile is estimated by finding the bucket holding the q * N-th observation and interpolating linearly between that bucket's bounds.
+
+There is no direct Windows counterpart to this counter type.
+
+*/
+
+// LatencyBuckets are the default upper bounds, in milliseconds, used for latency histograms.
+var LatencyBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000}
+
+type Histogram struct {
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+	min    float64
+	max    float64
+	mu     sync.Mutex
+}
+
+// NewHistogram creates a histogram with the given ascending bucket upper bounds. Values above the
+// last bound are counted in an overflow bucket.
+func NewHistogram(bounds ...float64) *Histogram {
+	util.Require(len(bounds) > 0, "perfcounters: bounds cannot be empty.")
+	util.Require(sort.Float64sAreSorted(bounds), "perfcounters: bounds must be sorted.")
+
+	return &Histogram{
+		bounds: append([]float64(nil), bounds...),
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+func (self *Histogram) Add(value float64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.counts[sort.SearchFloat64s(self.bounds, value)] += 1
+
+	if self.count == 0 || value < self.min {
+		self.min = value
+	}
+
+	if self.count == 0 || value > self.max {
+		self.max = value
+	}
+
+	self.count += 1
+	self.sum += value
+}
+
+func (self *Histogram) Count() int64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.count
+}
+
+func (self *Histogram) Sum() float64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.sum
+}
+
+func (self *Histogram) Mean() float64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.count == 0 {
+		return 0
+	}
+
+	return self.sum / float64(self.count)
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the observed values.
+func (self *Histogram) Quantile(q float64) float64 {
+	util.Require(q >= 0 && q <= 1, "perfcounters: q must be between 0 and 1.")
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.count == 0 {
+		return 0
+	}
+
+	rank := q * float64(self.count)
+	var seen int64
+
+	for i, count := range self.counts {
+		if count == 0 || float64(seen+count) < rank {
+			seen += count
+			continue
+		}
+
+		lower, upper := self.min, self.max
+
+		if i > 0 && self.bounds[i-1] > lower {
+			lower = self.bounds[i-1]
+		}
+
+		if i < len(self.bounds) && self.bounds[i] < upper {
+			upper = self.bounds[i]
+		}
+
+		return lower + (upper-lower)*(rank-float64(seen))/float64(count)
+	}
+
+	return self.max
+}
+
+// Buckets returns the bucket upper bounds and the number of values counted in each. The last
+// count is the overflow bucket, whose upper bound is +Inf.
+func (self *Histogram) Buckets() ([]float64, []int64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return append(append([]float64(nil), self.bounds...), math.Inf(1)), append([]int64(nil), self.counts...)
+}
+
+func (self *Histogram) String() string {
+
+	summary := struct {
+		Count int64   `json:"count"`
+		Mean  float64 `json:"mean"`
+		P50   float64 `json:"p50"`
+		P90   float64 `json:"p90"`
+		P99   float64 `json:"p99"`
+	}{
+		self.Count(), self.Mean(), self.Quantile(0.5), self.Quantile(0.9), self.Quantile(0.99),
+	}
+
+	b, _ := json.Marshal(summary)
+	return string(b)
+}
@@ -0,0 +1,257 @@
+// Package influx pushes perfcounters samples to InfluxDB using the line protocol over HTTP. An
+// Exporter is a perfcounters.Sink; attach it to a perfcounters.Sampler to push every registered
+// counter on each sampler tick.
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/israelchen/gomon/perfcounters"
+	"github.com/israelchen/gomon/perfcounters/internal/push"
+	"github.com/israelchen/gomon/util"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxBatches = 60
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 5 * time.Minute
+	defaultTimeout    = 10 * time.Second
+)
+
+// Exporter writes each sampler tick as one batch of line protocol points, POSTed to a write
+// endpoint such as "http://localhost:8086/write?db=mydb" (v1) or
+// "http://localhost:8086/api/v2/write?org=o&bucket=b" (v2).
+//
+// A counter name is split at its last dot into a measurement and a field, so the counters of a
+// telemetry map become fields of a single point: "foo.totalCalls" and "foo.failedCalls" are
+// written as "foo totalCalls=5,failedCalls=1". Labels, such as those of counter vectors and the
+// instance of category counters, and the global tags of the exporter become tags, so that the
+// instances of a category share one measurement: "disks,instance=sda bytesPerSec=512". Names
+// without a dot are written as the field "value".
+//
+// Line protocol has no empty tag values and no newlines, so tags with an empty name or value are
+// left out, newlines are written as spaces, and samples with an empty name are skipped. A batch is
+// accepted or rejected by InfluxDB as a whole, so this keeps one bad sample from dropping a tick.
+//
+// Batches that cannot be sent are kept, up to a maximum, and retried with exponential backoff on
+// the following ticks.
+type Exporter struct {
+	url        string
+	client     *http.Client
+	header     http.Header
+	tags       []perfcounters.Label
+	maxBatches int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	queue      *push.Queue
+}
+
+type Option func(e *Exporter)
+
+// WithTags adds tags to every point, e.g. the host or service name.
+func WithTags(tags ...perfcounters.Label) Option {
+	return func(e *Exporter) {
+		e.tags = append(e.tags, tags...)
+	}
+}
+
+// WithHeader sets a header of every request, e.g. "Authorization" to "Token <token>".
+func WithHeader(key string, value string) Option {
+	return func(e *Exporter) {
+		e.header.Set(key, value)
+	}
+}
+
+// WithHTTPClient sets the client used to send requests. Defaults to a client with a 10s timeout.
+func WithHTTPClient(client *http.Client) Option {
+	util.Require(client != nil, "influx: client cannot be nil.")
+
+	return func(e *Exporter) {
+		e.client = client
+	}
+}
+
+// WithMaxBatches sets how many ticks are kept while InfluxDB cannot be reached. Defaults to 60.
+func WithMaxBatches(max int) Option {
+	util.Require(max > 0, "influx: max must be positive.")
+
+	return func(e *Exporter) {
+		e.maxBatches = max
+	}
+}
+
+// WithBackoff sets the backoff of retries, see push.NewQueue. Defaults to 1s and 5m.
+func WithBackoff(min time.Duration, max time.Duration) Option {
+	util.Require(min > 0 && max >= min, "influx: invalid backoff.")
+
+	return func(e *Exporter) {
+		e.minBackoff = min
+		e.maxBackoff = max
+	}
+}
+
+// NewExporter creates an exporter POSTing to the write endpoint at url. Timestamps are written in
+// nanoseconds, the default precision of both API versions.
+func NewExporter(url string, options ...Option) *Exporter {
+	util.Require(len(url) > 0, "influx: url cannot be empty.")
+
+	exporter := &Exporter{
+		url:        url,
+		client:     &http.Client{Timeout: defaultTimeout},
+		header:     make(http.Header),
+		maxBatches: defaultMaxBatches,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+
+	for _, option := range options {
+		option(exporter)
+	}
+
+	exporter.queue = push.NewQueue(exporter.send, exporter.maxBatches, exporter.minBackoff, exporter.maxBackoff)
+
+	return exporter
+}
+
+// point is the measurement and tags of a line, and the fields gathered for it.
+type point struct {
+	series string
+	fields []string
+}
+
+// Write formats the samples as points and pushes them as one batch.
+func (self *Exporter) Write(at time.Time, samples []perfcounters.Sample) error {
+
+	var points []*point
+	byKey := make(map[string]*point)
+
+	for _, sample := range samples {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) || len(sample.Name) == 0 {
+			continue
+		}
+
+		measurement, field := sample.Name, "value"
+
+		if i := strings.LastIndexByte(sample.Name, '.'); i > 0 && i < len(sample.Name)-1 {
+			measurement, field = sample.Name[:i], sample.Name[i+1:]
+		}
+
+		tags := append(append([]perfcounters.Label(nil), self.tags...), sample.Labels...)
+		sort.SliceStable(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+		var key strings.Builder
+		key.WriteString(escape(measurement, ", "))
+
+		for _, tag := range tags {
+			if len(tag.Name) == 0 || len(tag.Value) == 0 {
+				continue
+			}
+
+			key.WriteString("," + escape(tag.Name, ",= ") + "=" + escape(tag.Value, ",= "))
+		}
+
+		p, ok := byKey[key.String()]
+
+		if !ok {
+			p = &point{series: key.String()}
+			byKey[key.String()] = p
+			points = append(points, p)
+		}
+
+		p.fields = append(p.fields, escape(field, ",= ")+"="+strconv.FormatFloat(sample.Value, 'f', -1, 64))
+	}
+
+	var batch bytes.Buffer
+	timestamp := strconv.FormatInt(at.UnixNano(), 10)
+
+	for _, p := range points {
+		batch.WriteString(p.series + " " + strings.Join(p.fields, ",") + " " + timestamp + "\n")
+	}
+
+	return self.queue.Push(at, batch.Bytes())
+}
+
+// Pending returns the number of batches waiting to be sent.
+func (self *Exporter) Pending() int {
+	return self.queue.Len()
+}
+
+// Dropped returns the number of batches dropped because too many were waiting to be sent, or
+// because InfluxDB rejected them.
+func (self *Exporter) Dropped() int64 {
+	return self.queue.Dropped()
+}
+
+// send POSTs the batch, which is written whole or not at all.
+func (self *Exporter) send(batch []byte) (int, error) {
+
+	request, err := http.NewRequest(http.MethodPost, self.url, bytes.NewReader(batch))
+
+	if err != nil {
+		return 0, err
+	}
+
+	for key, values := range self.header {
+		request.Header[key] = values
+	}
+
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	response, err := self.client.Do(request)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		err := fmt.Errorf("influx: write failed with %s: %s", response.Status, strings.TrimSpace(string(body)))
+
+		// the batch itself was refused, retrying it would fail again.
+		if response.StatusCode/100 == 4 && response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusRequestTimeout {
+			return 0, &push.RejectedError{Err: err}
+		}
+
+		return 0, err
+	}
+
+	io.Copy(io.Discard, response.Body)
+	return len(batch), nil
+}
+
+// escape backslash-escapes the given characters, which delimit the parts of a line, and replaces
+// newlines, which cannot be escaped, with spaces. Trailing backslashes are doubled so that they do
+// not escape the delimiter that follows.
+func escape(s string, chars string) string {
+
+	s = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+
+	if trimmed := strings.TrimRight(s, "\\"); len(trimmed) < len(s) {
+		s += s[len(trimmed):]
+	}
+
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+
+	var b strings.Builder
+
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
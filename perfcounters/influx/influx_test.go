@@ -0,0 +1,127 @@
+package influx
+
+import (
+	"github.com/israelchen/gomon/perfcounters"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExporter(t *testing.T) {
+
+	var bodies []string
+	statuses := []int{http.StatusServiceUnavailable, http.StatusNoContent, http.StatusBadRequest}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.Header.Get("Authorization")+"|"+string(body))
+
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+
+	defer server.Close()
+
+	exporter := NewExporter(server.URL+"/write?db=test", WithTags(perfcounters.Label{Name: "host", Value: "a b"}), WithHeader("Authorization", "Token x"), WithBackoff(time.Second, time.Minute))
+
+	start := time.Unix(1700000000, 0)
+	samples := []perfcounters.Sample{
+		{Name: "foo.totalCalls", Value: 5},
+		{Name: "foo.failedCalls", Value: 1},
+		{Name: "requests", Labels: []perfcounters.Label{{Name: "route", Value: "/a,b"}}, Value: 1.5},
+	}
+
+	if err := exporter.Write(start, samples); err == nil || exporter.Pending() != 1 {
+		t.Fatalf("Expected the batch to be kept after failing, got %v and %d pending.", err, exporter.Pending())
+	}
+
+	if err := exporter.Write(start.Add(2*time.Second), nil); err != nil || exporter.Pending() != 0 {
+		t.Fatalf("Expected the batch to be sent, got %v and %d pending.", err, exporter.Pending())
+	}
+
+	expected := "Token x|foo,host=a\\ b totalCalls=5,failedCalls=1 1700000000000000000\nrequests,host=a\\ b,route=/a\\,b value=1.5 1700000000000000000\n"
+
+	if len(bodies) != 2 || bodies[0] != expected || bodies[1] != expected {
+		t.Errorf("Requests are different than expected: %q.", bodies)
+	}
+
+	// rejected batches are dropped rather than retried.
+	if err := exporter.Write(start.Add(3*time.Second), samples); err == nil || exporter.Pending() != 0 || exporter.Dropped() != 1 {
+		t.Errorf("Expected the batch to be dropped, got %v, %d pending and %d dropped.", err, exporter.Pending(), exporter.Dropped())
+	}
+}
+
+func TestExporterWritesInstancesAsTags(t *testing.T) {
+
+	var body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	defer server.Close()
+
+	category := perfcounters.NewCategory("test.influx.hosts",
+		perfcounters.CounterDefinition{Name: "requests", Type: perfcounters.NumberOfItems64Type},
+		perfcounters.CounterDefinition{Name: "errors", Type: perfcounters.NumberOfItems64Type})
+
+	category.Instance("a").Add("requests", 2)
+	category.Instance("b").Add("errors", 1)
+
+	var samples []perfcounters.Sample
+
+	for _, sample := range perfcounters.DefaultRegistry.Sample() {
+		if strings.HasPrefix(sample.Name, "test.influx.hosts.") {
+			samples = append(samples, sample)
+		}
+	}
+
+	if err := NewExporter(server.URL).Write(time.Unix(1, 0), samples); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "test.influx.hosts,instance=_Total errors=1,requests=2 1000000000\n" +
+		"test.influx.hosts,instance=a errors=0,requests=2 1000000000\n" +
+		"test.influx.hosts,instance=b errors=1,requests=0 1000000000\n"
+
+	if body != expected {
+		t.Errorf("Points are different than expected: %q.", body)
+	}
+}
+
+func TestExporterSkipsInvalidPoints(t *testing.T) {
+
+	var body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	defer server.Close()
+
+	exporter := NewExporter(server.URL + "/write?db=test")
+
+	samples := []perfcounters.Sample{
+		{Name: "", Value: 1},
+		{Name: "requests", Labels: []perfcounters.Label{{Name: "route", Value: ""}, {Name: "", Value: "x"}}, Value: 2},
+		{Name: "multi\nline.count", Labels: []perfcounters.Label{{Name: "path", Value: `c:\\`}}, Value: 3},
+	}
+
+	if err := exporter.Write(time.Unix(1700000000, 0), samples); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "requests value=2 1700000000000000000\nmulti\\ line,path=c:\\\\\\\\ count=3 1700000000000000000\n"
+
+	if body != expected {
+		t.Errorf("Expected %q, got %q.", expected, body)
+	}
+}
@@ -0,0 +1,127 @@
+// Package push holds the buffering shared by the exporters that push batches of samples to a
+// remote endpoint.
+package push
+
+import (
+	"github.com/israelchen/gomon/util"
+	"sync"
+	"time"
+)
+
+// RejectedError is returned by the send function of a Queue when the batch was refused and must
+// not be retried, e.g. because it is malformed.
+type RejectedError struct {
+	Err error
+}
+
+func (self *RejectedError) Error() string {
+	return self.Err.Error()
+}
+
+func (self *RejectedError) Unwrap() error {
+	return self.Err
+}
+
+// Queue keeps the batches that could not be sent yet, up to a maximum, and retries them with
+// exponential backoff. Attempts are made when a new batch is added, i.e. on sampler ticks, so a
+// backoff shorter than the sampler interval retries on every tick.
+//
+// The exporters built on a Queue share its behavior: their Write pushes one batch per sampler
+// tick, their WithMaxBatches and WithBackoff options set maxBatches, minBackoff and maxBackoff,
+// and their Pending and Dropped return Len and Dropped.
+type Queue struct {
+	send       func(batch []byte) (int, error)
+	maxBatches int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	backoff    time.Duration
+	retryAt    time.Time
+	batches    [][]byte
+	dropped    int64
+	mu         sync.Mutex
+}
+
+// NewQueue creates a queue keeping up to maxBatches batches. After a failure, no attempt is made
+// for minBackoff, and the delay doubles with every further failure up to maxBackoff.
+//
+// send returns how many bytes of the batch it sent, even when failing; those are not sent again,
+// so a send writing to a stream should only count whole lines.
+func NewQueue(send func(batch []byte) (int, error), maxBatches int, minBackoff time.Duration, maxBackoff time.Duration) *Queue {
+	util.Require(send != nil, "push: send cannot be nil.")
+	util.Require(maxBatches > 0, "push: maxBatches must be positive.")
+	util.Require(minBackoff > 0 && maxBackoff >= minBackoff, "push: invalid backoff.")
+
+	return &Queue{
+		send:       send,
+		maxBatches: maxBatches,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+	}
+}
+
+// Push queues a batch, dropping the oldest one when full, then sends the queued batches in order
+// unless backing off. It returns the error of a failed attempt, after which the part of the batch
+// that was not sent is kept for the next attempt, or dropped when it was rejected.
+func (self *Queue) Push(now time.Time, batch []byte) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if len(batch) > 0 {
+		if len(self.batches) == self.maxBatches {
+			self.batches = self.batches[1:]
+			self.dropped += 1
+		}
+
+		self.batches = append(self.batches, batch)
+	}
+
+	if now.Before(self.retryAt) {
+		return nil
+	}
+
+	for len(self.batches) > 0 {
+		n, err := self.send(self.batches[0])
+
+		if err != nil {
+			if _, rejected := err.(*RejectedError); rejected {
+				self.batches = self.batches[1:]
+				self.dropped += 1
+				return err
+			}
+
+			if self.batches[0] = self.batches[0][n:]; len(self.batches[0]) == 0 {
+				self.batches = self.batches[1:]
+			}
+
+			if self.backoff == 0 {
+				self.backoff = self.minBackoff
+			} else if self.backoff *= 2; self.backoff > self.maxBackoff {
+				self.backoff = self.maxBackoff
+			}
+
+			self.retryAt = now.Add(self.backoff)
+			return err
+		}
+
+		self.batches = self.batches[1:]
+	}
+
+	self.backoff = 0
+	return nil
+}
+
+// Len returns the number of batches waiting to be sent.
+func (self *Queue) Len() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return len(self.batches)
+}
+
+// Dropped returns the number of batches dropped because the queue was full or they were rejected.
+func (self *Queue) Dropped() int64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.dropped
+}
@@ -0,0 +1,456 @@
+// Package linux exposes Linux system counters under the categories familiar from Windows
+// performance counters: Processor, Memory, PhysicalDisk and Network Interface. They are read from
+// /proc/stat, /proc/meminfo, /proc/diskstats and /proc/net/dev using the counter types of the
+// perfcounters package.
+package linux
+
+import (
+	"expvar"
+	"github.com/israelchen/gomon/perfcounters"
+	"github.com/israelchen/gomon/util"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Processor mirrors the Processor category for a single cpu, or for all of them as "_Total".
+type Processor struct {
+	// % Processor Time: the percentage of time the cpu was not idle.
+	ProcessorTime *perfcounters.AverageCount64
+
+	// % User Time: the percentage of time spent in user mode.
+	UserTime *perfcounters.AverageCount64
+
+	// % Privileged Time: the percentage of time spent in kernel mode, including interrupts.
+	PrivilegedTime *perfcounters.AverageCount64
+}
+
+// Memory mirrors the Memory category.
+type Memory struct {
+	// Available Bytes: memory available for starting new applications without swapping.
+	AvailableBytes *perfcounters.NumberOfItems64
+
+	// Committed Bytes: memory committed to processes, whether or not it is backed yet.
+	CommittedBytes *perfcounters.NumberOfItems64
+
+	// Cache Bytes: memory used by the page cache.
+	CacheBytes *perfcounters.NumberOfItems64
+
+	// Total Bytes: usable physical memory.
+	TotalBytes *perfcounters.NumberOfItems64
+}
+
+// PhysicalDisk mirrors the PhysicalDisk category for a block device.
+type PhysicalDisk struct {
+	DiskReadsPerSec         *perfcounters.RateOfCountsPerSecond64
+	DiskWritesPerSec        *perfcounters.RateOfCountsPerSecond64
+	DiskReadBytesPerSec     *perfcounters.RateOfCountsPerSecond64
+	DiskWriteBytesPerSec    *perfcounters.RateOfCountsPerSecond64
+	AvgDiskBytesPerTransfer *perfcounters.AverageCount64
+
+	// Avg. Disk sec/Transfer, in milliseconds.
+	AvgDiskMillisPerTransfer *perfcounters.AverageCount64
+
+	CurrentDiskQueueLength *perfcounters.NumberOfItems64
+}
+
+// NetworkInterface mirrors the Network Interface category for an interface.
+type NetworkInterface struct {
+	BytesReceivedPerSec   *perfcounters.RateOfCountsPerSecond64
+	BytesSentPerSec       *perfcounters.RateOfCountsPerSecond64
+	PacketsReceivedPerSec *perfcounters.RateOfCountsPerSecond64
+	PacketsSentPerSec     *perfcounters.RateOfCountsPerSecond64
+	PacketsReceivedErrors *perfcounters.NumberOfItems64
+	PacketsOutboundErrors *perfcounters.NumberOfItems64
+}
+
+// Collector periodically reads the /proc files below its root into the category counters. Rates
+// and averages are fed with the difference between two collections, so they start reporting from
+// the second one.
+//
+// Counters are published in expvar under the collector's name, as nested maps of category and
+// instance, and registered with perfcounters.DefaultRegistry like the instances of a
+// perfcounters.Category, e.g. "<name>.physicalDisk.diskReadsPerSec" labelled instance=sda.
+// Instances that appear later, like a new disk, are added as they are first seen.
+type Collector struct {
+	name     string
+	root     string
+	interval time.Duration
+	expvar   *expvar.Map
+
+	processors map[string]*Processor
+	memory     *Memory
+	disks      map[string]*PhysicalDisk
+	interfaces map[string]*NetworkInterface
+
+	previousCPU  map[string]cpuTimes
+	previousDisk map[string]diskStats
+	previousNet  map[string]interfaceStats
+
+	stop chan struct{}
+	mu   sync.Mutex
+}
+
+// NewCollector creates a collector reading from root, which defaults to /proc when empty and can
+// point to fixture files in tests.
+func NewCollector(name string, root string, interval time.Duration) *Collector {
+	util.Require(len(name) > 0, "linux: name cannot be empty.")
+	util.Require(interval > 0, "linux: interval must be positive.")
+
+	if len(root) == 0 {
+		root = "/proc"
+	}
+
+	collector := &Collector{
+		name:         name,
+		root:         root,
+		interval:     interval,
+		expvar:       expvar.NewMap(name),
+		processors:   make(map[string]*Processor),
+		disks:        make(map[string]*PhysicalDisk),
+		interfaces:   make(map[string]*NetworkInterface),
+		previousCPU:  make(map[string]cpuTimes),
+		previousDisk: make(map[string]diskStats),
+		previousNet:  make(map[string]interfaceStats),
+	}
+
+	collector.memory = &Memory{
+		AvailableBytes: perfcounters.NewNumberOfItems64(),
+		CommittedBytes: perfcounters.NewNumberOfItems64(),
+		CacheBytes:     perfcounters.NewNumberOfItems64(),
+		TotalBytes:     perfcounters.NewNumberOfItems64(),
+	}
+
+	collector.publish("memory", "", map[string]expvar.Var{
+		"availableBytes": collector.memory.AvailableBytes,
+		"committedBytes": collector.memory.CommittedBytes,
+		"cacheBytes":     collector.memory.CacheBytes,
+		"totalBytes":     collector.memory.TotalBytes,
+	})
+
+	return collector
+}
+
+// Start collects every interval until Stop is called. Collection errors, e.g. on systems without
+// /proc, are ignored.
+func (self *Collector) Start() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	util.Require(self.stop == nil, "linux: collector is already started.")
+
+	self.stop = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(self.interval)
+		defer ticker.Stop()
+
+		self.Collect()
+
+		for {
+			select {
+			case <-ticker.C:
+				self.Collect()
+			case <-stop:
+				return
+			}
+		}
+	}(self.stop)
+}
+
+func (self *Collector) Stop() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.stop != nil {
+		close(self.stop)
+		self.stop = nil
+	}
+}
+
+// Processor returns the counters of a cpu, e.g. "cpu0" or "_Total", or nil if it was not seen.
+func (self *Collector) Processor(name string) *Processor {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.processors[name]
+}
+
+func (self *Collector) Memory() *Memory {
+	return self.memory
+}
+
+// PhysicalDisk returns the counters of a block device, e.g. "sda", or nil if it was not seen.
+func (self *Collector) PhysicalDisk(name string) *PhysicalDisk {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.disks[name]
+}
+
+// NetworkInterface returns the counters of an interface, e.g. "eth0", or nil if it was not seen.
+func (self *Collector) NetworkInterface(name string) *NetworkInterface {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.interfaces[name]
+}
+
+// Collect reads every source once. All sources are read even if some fail; the first error is
+// returned.
+func (self *Collector) Collect() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	var first error
+
+	for _, collect := range []func() error{self.collectProcessors, self.collectMemory, self.collectDisks, self.collectInterfaces} {
+		if err := collect(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+func (self *Collector) collectProcessors() error {
+
+	f, err := self.open("stat")
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	cpus, err := parseStat(f)
+
+	if err != nil {
+		return err
+	}
+
+	for name, current := range cpus {
+		processor, ok := self.processors[name]
+
+		if !ok {
+			processor = &Processor{
+				ProcessorTime:  perfcounters.NewAverageCount64(),
+				UserTime:       perfcounters.NewAverageCount64(),
+				PrivilegedTime: perfcounters.NewAverageCount64(),
+			}
+
+			self.processors[name] = processor
+
+			self.publish("processor", name, map[string]expvar.Var{
+				"processorTime":  processor.ProcessorTime,
+				"userTime":       processor.UserTime,
+				"privilegedTime": processor.PrivilegedTime,
+			})
+		}
+
+		if previous, ok := self.previousCPU[name]; ok {
+			total := int64(delta(current.total(), previous.total()))
+
+			if total > 0 {
+				processor.ProcessorTime.AddWithBase(100*(total-int64(delta(current.idleTotal(), previous.idleTotal()))), total)
+				processor.UserTime.AddWithBase(100*int64(delta(current.user+current.nice, previous.user+previous.nice)), total)
+				processor.PrivilegedTime.AddWithBase(100*int64(delta(current.system+current.irq+current.softirq, previous.system+previous.irq+previous.softirq)), total)
+			}
+		}
+
+		self.previousCPU[name] = current
+	}
+
+	return nil
+}
+
+func (self *Collector) collectMemory() error {
+
+	f, err := self.open("meminfo")
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	info, err := parseMeminfo(f)
+
+	if err != nil {
+		return err
+	}
+
+	self.memory.AvailableBytes.Set(int64(info["MemAvailable"]))
+	self.memory.CommittedBytes.Set(int64(info["Committed_AS"]))
+	self.memory.CacheBytes.Set(int64(info["Cached"]))
+	self.memory.TotalBytes.Set(int64(info["MemTotal"]))
+
+	return nil
+}
+
+func (self *Collector) collectDisks() error {
+
+	f, err := self.open("diskstats")
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	disks, err := parseDiskstats(f)
+
+	if err != nil {
+		return err
+	}
+
+	for name, current := range disks {
+		disk, ok := self.disks[name]
+
+		if !ok {
+			disk = &PhysicalDisk{
+				DiskReadsPerSec:          perfcounters.NewRateOfCountsPerSecond64(),
+				DiskWritesPerSec:         perfcounters.NewRateOfCountsPerSecond64(),
+				DiskReadBytesPerSec:      perfcounters.NewRateOfCountsPerSecond64(),
+				DiskWriteBytesPerSec:     perfcounters.NewRateOfCountsPerSecond64(),
+				AvgDiskBytesPerTransfer:  perfcounters.NewAverageCount64(),
+				AvgDiskMillisPerTransfer: perfcounters.NewAverageCount64(),
+				CurrentDiskQueueLength:   perfcounters.NewNumberOfItems64(),
+			}
+
+			self.disks[name] = disk
+
+			self.publish("physicalDisk", name, map[string]expvar.Var{
+				"diskReadsPerSec":          disk.DiskReadsPerSec,
+				"diskWritesPerSec":         disk.DiskWritesPerSec,
+				"diskReadBytesPerSec":      disk.DiskReadBytesPerSec,
+				"diskWriteBytesPerSec":     disk.DiskWriteBytesPerSec,
+				"avgDiskBytesPerTransfer":  disk.AvgDiskBytesPerTransfer,
+				"avgDiskMillisPerTransfer": disk.AvgDiskMillisPerTransfer,
+				"currentDiskQueueLength":   disk.CurrentDiskQueueLength,
+			})
+		}
+
+		disk.CurrentDiskQueueLength.Set(int64(current.inProgress))
+
+		if previous, ok := self.previousDisk[name]; ok {
+			reads := delta(current.reads, previous.reads)
+			writes := delta(current.writes, previous.writes)
+			bytesRead := sectorSize * delta(current.sectorsRead, previous.sectorsRead)
+			bytesWritten := sectorSize * delta(current.sectorsWritten, previous.sectorsWritten)
+
+			disk.DiskReadsPerSec.Add(int64(reads))
+			disk.DiskWritesPerSec.Add(int64(writes))
+			disk.DiskReadBytesPerSec.Add(int64(bytesRead))
+			disk.DiskWriteBytesPerSec.Add(int64(bytesWritten))
+
+			if transfers := int64(reads + writes); transfers > 0 {
+				disk.AvgDiskBytesPerTransfer.AddWithBase(int64(bytesRead+bytesWritten), transfers)
+				disk.AvgDiskMillisPerTransfer.AddWithBase(int64(delta(current.msReading+current.msWriting, previous.msReading+previous.msWriting)), transfers)
+			}
+		}
+
+		self.previousDisk[name] = current
+	}
+
+	return nil
+}
+
+func (self *Collector) collectInterfaces() error {
+
+	f, err := self.open("net/dev")
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	interfaces, err := parseNetDev(f)
+
+	if err != nil {
+		return err
+	}
+
+	for name, current := range interfaces {
+		iface, ok := self.interfaces[name]
+
+		if !ok {
+			iface = &NetworkInterface{
+				BytesReceivedPerSec:   perfcounters.NewRateOfCountsPerSecond64(),
+				BytesSentPerSec:       perfcounters.NewRateOfCountsPerSecond64(),
+				PacketsReceivedPerSec: perfcounters.NewRateOfCountsPerSecond64(),
+				PacketsSentPerSec:     perfcounters.NewRateOfCountsPerSecond64(),
+				PacketsReceivedErrors: perfcounters.NewNumberOfItems64(),
+				PacketsOutboundErrors: perfcounters.NewNumberOfItems64(),
+			}
+
+			self.interfaces[name] = iface
+
+			self.publish("networkInterface", name, map[string]expvar.Var{
+				"bytesReceivedPerSec":   iface.BytesReceivedPerSec,
+				"bytesSentPerSec":       iface.BytesSentPerSec,
+				"packetsReceivedPerSec": iface.PacketsReceivedPerSec,
+				"packetsSentPerSec":     iface.PacketsSentPerSec,
+				"packetsReceivedErrors": iface.PacketsReceivedErrors,
+				"packetsOutboundErrors": iface.PacketsOutboundErrors,
+			})
+		}
+
+		iface.PacketsReceivedErrors.Set(int64(current.receiveErrors))
+		iface.PacketsOutboundErrors.Set(int64(current.sendErrors))
+
+		if previous, ok := self.previousNet[name]; ok {
+			iface.BytesReceivedPerSec.Add(int64(delta(current.bytesReceived, previous.bytesReceived)))
+			iface.BytesSentPerSec.Add(int64(delta(current.bytesSent, previous.bytesSent)))
+			iface.PacketsReceivedPerSec.Add(int64(delta(current.packetsReceived, previous.packetsReceived)))
+			iface.PacketsSentPerSec.Add(int64(delta(current.packetsSent, previous.packetsSent)))
+		}
+
+		self.previousNet[name] = current
+	}
+
+	return nil
+}
+
+// publish adds the counters of a category instance to expvar and the default registry. Categories
+// without instances, like memory, pass an empty instance name.
+func (self *Collector) publish(category string, instance string, counters map[string]expvar.Var) {
+
+	categoryMap, ok := self.expvar.Get(category).(*expvar.Map)
+
+	if !ok {
+		categoryMap = new(expvar.Map).Init()
+		self.expvar.Set(category, categoryMap)
+	}
+
+	prefix := self.name + "." + category + "."
+	target := categoryMap
+	var labels []perfcounters.Label
+
+	if len(instance) > 0 {
+		labels = []perfcounters.Label{{Name: perfcounters.InstanceLabel, Value: instance}}
+		target = new(expvar.Map).Init()
+		categoryMap.Set(instance, target)
+	}
+
+	for name, counter := range counters {
+		target.Set(name, counter)
+		perfcounters.DefaultRegistry.RegisterWithLabels(prefix+name, labels, counter)
+	}
+}
+
+// delta returns the increase of a running total, treating a decrease as the total having been reset.
+func delta(current uint64, previous uint64) uint64 {
+
+	if current < previous {
+		return current
+	}
+
+	return current - previous
+}
+
+func (self *Collector) open(name string) (*os.File, error) {
+	return os.Open(filepath.Join(self.root, name))
+}
@@ -0,0 +1,92 @@
+package linux
+
+import (
+	"github.com/israelchen/gomon/perfcounters"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// copyProc copies the fixture files of a /proc snapshot into root.
+func copyProc(t *testing.T, snapshot string, root string) {
+
+	for _, name := range []string{"stat", "meminfo", "diskstats", "net/dev"} {
+		b, err := os.ReadFile(filepath.Join("testdata", snapshot, name))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+
+		if err := os.WriteFile(filepath.Join(root, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+
+	root := t.TempDir()
+	copyProc(t, "proc1", root)
+
+	collector := NewCollector("test.linux", root, time.Hour)
+
+	if err := collector.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if collector.Memory().AvailableBytes.Value() != 8192000*1024 {
+		t.Errorf("Expected 8192000 kB available, got %d bytes.", collector.Memory().AvailableBytes.Value())
+	}
+
+	if collector.PhysicalDisk("sda").CurrentDiskQueueLength.Value() != 1 {
+		t.Error("Expected a disk queue length of 1.")
+	}
+
+	copyProc(t, "proc2", root)
+
+	if err := collector.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// _Total spent 1000 of 1600 jiffies busy, of which 600 in user and 400 in kernel mode.
+	total := collector.Processor("_Total")
+
+	processorTime, userTime, privilegedTime := total.ProcessorTime.CalculatedValue(), total.UserTime.CalculatedValue(), total.PrivilegedTime.CalculatedValue()
+
+	if processorTime != 62.5 || userTime != 37.5 || privilegedTime != 25 {
+		t.Errorf("Processor times are different than expected: %v, %v, %v.", processorTime, userTime, privilegedTime)
+	}
+
+	if processorTime := collector.Processor("cpu1").ProcessorTime.CalculatedValue(); processorTime != 0 {
+		t.Errorf("Expected cpu1 to be idle, got %v.", processorTime)
+	}
+
+	// sda did 400 transfers of 1000 + 2000 sectors, spending 400ms.
+	sda := collector.PhysicalDisk("sda")
+
+	bytesPerTransfer, millisPerTransfer := sda.AvgDiskBytesPerTransfer.CalculatedValue(), sda.AvgDiskMillisPerTransfer.CalculatedValue()
+
+	if bytesPerTransfer != 3840 || millisPerTransfer != 1 {
+		t.Errorf("Disk averages are different than expected: %v, %v.", bytesPerTransfer, millisPerTransfer)
+	}
+
+	if errors := collector.NetworkInterface("eth0").PacketsReceivedErrors.Value(); errors != 3 {
+		t.Errorf("Expected 3 receive errors on eth0, got %d.", errors)
+	}
+
+	if perfcounters.DefaultRegistry.Get("test.linux.networkInterface.bytesSentPerSec{instance=eth0}") == nil {
+		t.Error("Network interface counters were not registered.")
+	}
+}
+
+func TestCollectorWithoutProc(t *testing.T) {
+
+	collector := NewCollector("test.linux.missing", t.TempDir(), time.Hour)
+
+	if err := collector.Collect(); err == nil {
+		t.Error("Expected an error for missing /proc files.")
+	}
+}
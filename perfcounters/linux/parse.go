@@ -0,0 +1,182 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cpuTimes are the jiffies a cpu line of /proc/stat reports for each mode.
+type cpuTimes struct {
+	user, nice, system, idle, iowait, irq, softirq, steal uint64
+}
+
+func (t cpuTimes) total() uint64 {
+	return t.user + t.nice + t.system + t.idle + t.iowait + t.irq + t.softirq + t.steal
+}
+
+func (t cpuTimes) idleTotal() uint64 {
+	return t.idle + t.iowait
+}
+
+// parseStat returns the cpu times of /proc/stat by cpu name, with the aggregate "cpu" line
+// under "_Total".
+func parseStat(r io.Reader) (map[string]cpuTimes, error) {
+
+	cpus := make(map[string]cpuTimes)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		values, err := parseUints(fields[1:9])
+
+		if err != nil {
+			return nil, fmt.Errorf("linux: invalid stat line %q: %w", scanner.Text(), err)
+		}
+
+		name := fields[0]
+
+		if name == "cpu" {
+			name = "_Total"
+		}
+
+		cpus[name] = cpuTimes{values[0], values[1], values[2], values[3], values[4], values[5], values[6], values[7]}
+	}
+
+	return cpus, scanner.Err()
+}
+
+// parseMeminfo returns the values of /proc/meminfo in bytes, by field name.
+func parseMeminfo(r io.Reader) (map[string]uint64, error) {
+
+	info := make(map[string]uint64)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("linux: invalid meminfo line %q: %w", scanner.Text(), err)
+		}
+
+		if len(fields) > 2 && fields[2] == "kB" {
+			value *= 1024
+		}
+
+		info[strings.TrimSuffix(fields[0], ":")] = value
+	}
+
+	return info, scanner.Err()
+}
+
+// diskStats are the running totals /proc/diskstats reports for a block device.
+type diskStats struct {
+	reads, sectorsRead, msReading     uint64
+	writes, sectorsWritten, msWriting uint64
+	inProgress                        uint64
+}
+
+const sectorSize = 512
+
+// parseDiskstats returns the statistics of /proc/diskstats by device name.
+func parseDiskstats(r io.Reader) (map[string]diskStats, error) {
+
+	disks := make(map[string]diskStats)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 12 {
+			continue
+		}
+
+		values, err := parseUints(fields[3:12])
+
+		if err != nil {
+			return nil, fmt.Errorf("linux: invalid diskstats line %q: %w", scanner.Text(), err)
+		}
+
+		disks[fields[2]] = diskStats{
+			reads:          values[0],
+			sectorsRead:    values[2],
+			msReading:      values[3],
+			writes:         values[4],
+			sectorsWritten: values[6],
+			msWriting:      values[7],
+			inProgress:     values[8],
+		}
+	}
+
+	return disks, scanner.Err()
+}
+
+// interfaceStats are the running totals /proc/net/dev reports for a network interface.
+type interfaceStats struct {
+	bytesReceived, packetsReceived, receiveErrors uint64
+	bytesSent, packetsSent, sendErrors            uint64
+}
+
+// parseNetDev returns the statistics of /proc/net/dev by interface name.
+func parseNetDev(r io.Reader) (map[string]interfaceStats, error) {
+
+	interfaces := make(map[string]interfaceStats)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		name, counters, found := strings.Cut(scanner.Text(), ":")
+		fields := strings.Fields(counters)
+
+		// the two header lines have no colon
+		if !found || len(fields) < 16 {
+			continue
+		}
+
+		values, err := parseUints(fields[:16])
+
+		if err != nil {
+			return nil, fmt.Errorf("linux: invalid net/dev line %q: %w", scanner.Text(), err)
+		}
+
+		interfaces[strings.TrimSpace(name)] = interfaceStats{
+			bytesReceived:   values[0],
+			packetsReceived: values[1],
+			receiveErrors:   values[2],
+			bytesSent:       values[8],
+			packetsSent:     values[9],
+			sendErrors:      values[10],
+		}
+	}
+
+	return interfaces, scanner.Err()
+}
+
+func parseUints(fields []string) ([]uint64, error) {
+
+	values := make([]uint64, len(fields))
+
+	for i, field := range fields {
+		value, err := strconv.ParseUint(field, 10, 64)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
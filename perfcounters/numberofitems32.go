@@ -35,6 +35,15 @@ func (self *NumberOfItems32) Add(count int32) {
 	atomic.AddInt32(&(self.count), count)
 }
 
+// Set replaces the count with the most recently observed value.
+func (self *NumberOfItems32) Set(value int32) {
+	atomic.StoreInt32(&self.count, value)
+}
+
+func (self *NumberOfItems32) Value() int32 {
+	return atomic.LoadInt32(&self.count)
+}
+
 func (self *NumberOfItems32) String() string {
-	return strconv.Itoa(int(self.count))
+	return strconv.Itoa(int(self.Value()))
 }
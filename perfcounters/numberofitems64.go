@@ -35,7 +35,15 @@ func (self *NumberOfItems64) Add(count int64) {
 	atomic.AddInt64(&(self.count), count)
 }
 
+// Set replaces the count with the most recently observed value.
+func (self *NumberOfItems64) Set(value int64) {
+	atomic.StoreInt64(&self.count, value)
+}
+
+func (self *NumberOfItems64) Value() int64 {
+	return atomic.LoadInt64(&self.count)
+}
+
 func (self *NumberOfItems64) String() string {
-	value := atomic.LoadInt64(&self.count)
-	return strconv.FormatInt(value, 10)
+	return strconv.FormatInt(self.Value(), 10)
 }
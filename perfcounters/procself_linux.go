@@ -0,0 +1,32 @@
+package perfcounters
+
+import (
+	"fmt"
+	"os"
+)
+
+// readProcSelf returns the number of open file descriptors and the resident set size in bytes
+// of the current process.
+func readProcSelf() (int64, int64, error) {
+
+	fds, err := os.ReadDir("/proc/self/fd")
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	statm, err := os.ReadFile("/proc/self/statm")
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var size, resident int64
+
+	if _, err := fmt.Sscan(string(statm), &size, &resident); err != nil {
+		return 0, 0, err
+	}
+
+	// the listing includes the descriptor ReadDir opened to read it.
+	return int64(len(fds)) - 1, resident * int64(os.Getpagesize()), nil
+}
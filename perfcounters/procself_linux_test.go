@@ -0,0 +1,24 @@
+package perfcounters
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestReadProcSelfCountsOpenFDs(t *testing.T) {
+
+	var stat syscall.Stat_t
+	open := int64(0)
+
+	for fd := 0; fd < 4096; fd++ {
+		if syscall.Fstat(fd, &stat) == nil {
+			open += 1
+		}
+	}
+
+	fds, _, err := readProcSelf()
+
+	if err != nil || fds != open {
+		t.Errorf("Expected %d open descriptors, got %d (%v).", open, fds, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package perfcounters
+
+import (
+	"errors"
+)
+
+func readProcSelf() (int64, int64, error) {
+	return 0, 0, errors.New("perfcounters: /proc/self is only available on linux.")
+}
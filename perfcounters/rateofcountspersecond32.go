@@ -2,6 +2,8 @@ package perfcounters
 
 import (
 	"fmt"
+	"github.com/israelchen/gomon/clock"
+	"github.com/israelchen/gomon/util"
 	"math"
 	"sync"
 	"time"
@@ -24,16 +26,26 @@ Counters of this type include System\ File Read Operations/sec.
 type RateOfCountsPerSecond32 struct {
 	lastTime     *time.Time
 	lastCount    int32
+	stringTime   *time.Time
+	stringCount  int32
 	currentCount int32
+	clock        clock.Clock
 	mu           sync.Mutex
 }
 
 func NewRateOfCountsPerSecond32() *RateOfCountsPerSecond32 {
+	return NewRateOfCountsPerSecond32WithClock(clock.Real)
+}
+
+// NewRateOfCountsPerSecond32WithClock creates a counter measuring its intervals with c.
+func NewRateOfCountsPerSecond32WithClock(c clock.Clock) *RateOfCountsPerSecond32 {
+	util.Require(c != nil, "perfcounters: c cannot be nil.")
 
 	return &RateOfCountsPerSecond32{
 		lastTime:     nil,
 		lastCount:    0,
 		currentCount: 0,
+		clock:        c,
 	}
 }
 
@@ -48,24 +60,36 @@ func (self *RateOfCountsPerSecond32) Add(value int32) {
 	self.currentCount += value
 
 	if self.lastTime == nil {
-		lastTime := time.Now()
+		lastTime := self.clock.Now()
 		self.lastTime = &lastTime
 	}
+
+	if self.stringTime == nil {
+		stringTime := self.clock.Now()
+		self.stringTime = &stringTime
+	}
 }
 
 func (self *RateOfCountsPerSecond32) CalculatedValue() float64 {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
-	currentTime := time.Now()
+	return self.calculate(&self.lastTime, &self.lastCount)
+}
 
-	if self.lastTime == nil {
-		self.lastTime = &currentTime
+// calculate returns the rate since the readings at lastTimeAt and lastCountAt, which it advances.
+// Callers must hold the lock.
+func (self *RateOfCountsPerSecond32) calculate(lastTimeAt **time.Time, lastCountAt *int32) float64 {
+
+	currentTime := self.clock.Now()
+
+	if *lastTimeAt == nil {
+		*lastTimeAt = &currentTime
 		return 0
 	}
 
-	lastTime := self.lastTime
-	lastCount := self.lastCount
+	lastTime := *lastTimeAt
+	lastCount := *lastCountAt
 	currentCount := self.currentCount
 
 	diff := currentTime.Sub(*lastTime)
@@ -76,14 +100,17 @@ func (self *RateOfCountsPerSecond32) CalculatedValue() float64 {
 		calculatedValue = 0.0
 	}
 
-	self.lastCount = currentCount
-	self.lastTime = &currentTime
+	*lastCountAt = currentCount
+	*lastTimeAt = &currentTime
 
 	return calculatedValue
 }
 
 func (self *RateOfCountsPerSecond32) String() string {
-	return fmt.Sprintf("%.3f", self.CalculatedValue())
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return fmt.Sprintf("%.3f", self.calculate(&self.stringTime, &self.stringCount))
 }
 
 /*
@@ -0,0 +1,114 @@
+package perfcounters
+
+import (
+	"fmt"
+	"github.com/israelchen/gomon/clock"
+	"github.com/israelchen/gomon/util"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+
+RateOfCountsPerSecond64
+
+A difference counter that shows the average number of operations completed during each second of the sample interval. This counter type is the same as the
+RateOfCountsPerSecond32 type, but it uses larger fields to accommodate larger values to track a high-volume number of operations per second, such as a
+byte-transmission rate.
+Formula: (N 1 - N 0) / ((D 1 -D 0) / F), where N 1 and N 0 are performance counter readings, D 1 and D 0 are their corresponding time readings, and F represents the number of ticks per second.
+Counters of this type include System\ File Read Bytes/sec.
+
+[[source: https://msdn.microsoft.com/en-us/library/system.diagnostics.performancecountertype(v=vs.90).aspx]]
+
+*/
+
+type RateOfCountsPerSecond64 struct {
+	lastTime     *time.Time
+	lastCount    int64
+	stringTime   *time.Time
+	stringCount  int64
+	currentCount int64
+	clock        clock.Clock
+	mu           sync.Mutex
+}
+
+func NewRateOfCountsPerSecond64() *RateOfCountsPerSecond64 {
+	return NewRateOfCountsPerSecond64WithClock(clock.Real)
+}
+
+// NewRateOfCountsPerSecond64WithClock creates a counter measuring its intervals with c.
+func NewRateOfCountsPerSecond64WithClock(c clock.Clock) *RateOfCountsPerSecond64 {
+	util.Require(c != nil, "perfcounters: c cannot be nil.")
+
+	return &RateOfCountsPerSecond64{
+		lastTime:     nil,
+		lastCount:    0,
+		currentCount: 0,
+		clock:        c,
+	}
+}
+
+func (self *RateOfCountsPerSecond64) Increment() {
+	self.Add(1)
+}
+
+func (self *RateOfCountsPerSecond64) Add(value int64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.currentCount += value
+
+	if self.lastTime == nil {
+		lastTime := self.clock.Now()
+		self.lastTime = &lastTime
+	}
+
+	if self.stringTime == nil {
+		stringTime := self.clock.Now()
+		self.stringTime = &stringTime
+	}
+}
+
+func (self *RateOfCountsPerSecond64) CalculatedValue() float64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.calculate(&self.lastTime, &self.lastCount)
+}
+
+// calculate returns the rate since the readings at lastTimeAt and lastCountAt, which it advances.
+// Callers must hold the lock.
+func (self *RateOfCountsPerSecond64) calculate(lastTimeAt **time.Time, lastCountAt *int64) float64 {
+
+	currentTime := self.clock.Now()
+
+	if *lastTimeAt == nil {
+		*lastTimeAt = &currentTime
+		return 0
+	}
+
+	lastTime := *lastTimeAt
+	lastCount := *lastCountAt
+	currentCount := self.currentCount
+
+	diff := currentTime.Sub(*lastTime)
+
+	calculatedValue := float64(currentCount-lastCount) / diff.Seconds()
+
+	if math.IsNaN(calculatedValue) || math.IsInf(calculatedValue, 1) || math.IsInf(calculatedValue, -1) {
+		calculatedValue = 0.0
+	}
+
+	*lastCountAt = currentCount
+	*lastTimeAt = &currentTime
+
+	return calculatedValue
+}
+
+func (self *RateOfCountsPerSecond64) String() string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return fmt.Sprintf("%.3f", self.calculate(&self.stringTime, &self.stringCount))
+}
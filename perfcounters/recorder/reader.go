@@ -0,0 +1,275 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Point is a recorded value of a series.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Scan calls fn with every sample recorded in dir between from and to, inclusive, in time order.
+// A zero from or to leaves that end of the range open. Scanning stops at the first error returned
+// by fn. Damaged files are scanned up to the damage, and the first damage found is returned once
+// every file was scanned.
+func Scan(dir string, from time.Time, to time.Time, fn func(at time.Time, series string, value float64) error) error {
+
+	files, err := listFiles(dir)
+
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+
+	for i, file := range files {
+		// a file holds samples until the next one starts.
+		if !from.IsZero() && i+1 < len(files) && files[i+1].start.Before(from) {
+			continue
+		}
+
+		if !to.IsZero() && file.start.After(to) {
+			break
+		}
+
+		if err := scanFile(file.path, i == len(files)-1, from, to, fn); err != nil {
+			// damaged files do not keep the others from being scanned
+			if !errors.Is(err, errFormat) && !errors.Is(err, errCorrupt) {
+				return err
+			}
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// Query returns the points of a series, as named by perfcounters.Sample.Key, recorded in dir between from and
+// to.
+func Query(dir string, series string, from time.Time, to time.Time) ([]Point, error) {
+
+	var points []Point
+
+	err := Scan(dir, from, to, func(at time.Time, name string, value float64) error {
+		if name == series {
+			points = append(points, Point{at, value})
+		}
+
+		return nil
+	})
+
+	return points, err
+}
+
+// Series returns the names of the series recorded in dir, in order.
+func Series(dir string) ([]string, error) {
+
+	seen := make(map[string]bool)
+
+	err := Scan(dir, time.Time{}, time.Time{}, func(at time.Time, name string, value float64) error {
+		seen[name] = true
+		return nil
+	})
+
+	names := make([]string, 0, len(seen))
+
+	for name := range seen {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names, err
+}
+
+var (
+	errFormat  = errors.New("recorder: not a recorded file")
+	errCorrupt = errors.New("recorder: corrupt file")
+)
+
+// torn tells whether a read failed because the file ended, as it does after a crash in the middle
+// of a write.
+func torn(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// scanFile calls fn with the samples of a file. A truncated last record ends the file, and other
+// damage is reported as errCorrupt once the samples before it were scanned.
+func scanFile(path string, last bool, from time.Time, to time.Time, fn func(at time.Time, series string, value float64) error) error {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, len(magic)+1+8)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		// the recorder crashed right after creating the file.
+		if last && torn(err) {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %s", errFormat, path)
+	}
+
+	if string(header[:len(magic)]) != magic {
+		return fmt.Errorf("%w: %s", errFormat, path)
+	}
+
+	if header[len(magic)] != version {
+		return fmt.Errorf("recorder: unsupported version %d: %s", header[len(magic)], path)
+	}
+
+	at := time.Unix(0, int64(binary.LittleEndian.Uint64(header[len(magic)+1:])))
+	names := make(map[uint64]string)
+	offset := int64(len(header))
+
+	corrupt := func(reason string) error {
+		return fmt.Errorf("%w: %s: %s at offset %d", errCorrupt, path, reason, offset)
+	}
+
+	// check returns nil for a truncated record, which ends the file, and reports other errors.
+	check := func(err error, reason string) error {
+		if torn(err) {
+			return nil
+		}
+
+		return corrupt(reason)
+	}
+
+	for {
+		kind, err := reader.ReadByte()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		switch kind {
+		case recordDefine:
+			id, err := binary.ReadUvarint(reader)
+
+			if err != nil {
+				return check(err, "invalid series id")
+			}
+
+			length, err := binary.ReadUvarint(reader)
+
+			if err != nil {
+				return check(err, "invalid series name length")
+			}
+
+			if length > 1<<16 {
+				return corrupt("series name too long")
+			}
+
+			name := make([]byte, length)
+
+			if _, err := io.ReadFull(reader, name); err != nil {
+				return check(err, "invalid series name")
+			}
+
+			names[id] = string(name)
+
+		case recordSamples:
+			delta, err := binary.ReadVarint(reader)
+
+			if err != nil {
+				return check(err, "invalid time delta")
+			}
+
+			count, err := binary.ReadUvarint(reader)
+
+			if err != nil {
+				return check(err, "invalid sample count")
+			}
+
+			if count > 1<<20 {
+				return corrupt("too many samples")
+			}
+
+			type entry struct {
+				id    uint64
+				value float64
+			}
+
+			// only complete records are reported.
+			entries := make([]entry, 0, count)
+
+			for i := uint64(0); i < count; i++ {
+				id, err := binary.ReadUvarint(reader)
+
+				if err != nil {
+					return check(err, "invalid series id")
+				}
+
+				if _, ok := names[id]; !ok {
+					return corrupt("undefined series id")
+				}
+
+				var b [8]byte
+
+				if _, err := io.ReadFull(reader, b[:]); err != nil {
+					return check(err, "invalid value")
+				}
+
+				entries = append(entries, entry{id, math.Float64frombits(binary.LittleEndian.Uint64(b[:]))})
+			}
+
+			at = at.Add(time.Duration(delta))
+
+			if (!from.IsZero() && at.Before(from)) || (!to.IsZero() && at.After(to)) {
+				break
+			}
+
+			for _, e := range entries {
+				if err := fn(at, names[e.id], e.value); err != nil {
+					return err
+				}
+			}
+
+		default:
+			// file systems may leave the end of a file zeroed after a crash.
+			if kind == 0 && zeroed(reader) {
+				return nil
+			}
+
+			return corrupt(fmt.Sprintf("unknown record %q", kind))
+		}
+
+		offset, _ = file.Seek(0, io.SeekCurrent)
+		offset -= int64(reader.Buffered())
+	}
+}
+
+// zeroed tells whether the rest of the file holds only zeros.
+func zeroed(reader *bufio.Reader) bool {
+
+	for {
+		b, err := reader.ReadByte()
+
+		if err != nil {
+			return torn(err)
+		}
+
+		if b != 0 {
+			return false
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package recorder keeps the history of perfcounters samples on disk, like a lightweight perfmon
+// log (.blg). A Recorder is a perfcounters.Sink appending every sampler tick to rolling files in a
+// directory, and Query and Scan read the history back.
+//
+// Each file starts with a header holding its base time, followed by records:
+//
+//	'D' uvarint(id) uvarint(len) series         defines the id of a series, once per file
+//	'S' varint(delta) uvarint(n) n * (uvarint(id) float64)
+//	                                            the samples of a tick, delta being nanoseconds
+//	                                            since the previous tick or the base time
+//
+// Integers are varints and floats 8 bytes little endian. Files are self-contained, so expired ones
+// are simply deleted, and a truncated last record, e.g. after a crash, ends the file. A last file
+// whose header was cut short is skipped, while other damage is reported by Scan.
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/israelchen/gomon/perfcounters"
+	"github.com/israelchen/gomon/util"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	magic           = "GOMONLOG"
+	version         = 1
+	extension       = ".gmlog"
+	fileTimeLayout  = "20060102T150405.000000000Z"
+	recordDefine    = 'D'
+	recordSamples   = 'S'
+	defaultFileSize = 16 << 20
+	defaultFileSpan = time.Hour
+)
+
+// Recorder appends samples to the current file of its directory, starting a new file when the
+// current one grows past a size or a time span, and deleting files past the retention limits.
+type Recorder struct {
+	dir         string
+	maxFileSize int64
+	maxFileSpan time.Duration
+	maxAge      time.Duration
+	maxFiles    int
+	file        *os.File
+	writer      *bufio.Writer
+	size        int64
+	start       time.Time
+	last        time.Time
+	ids         map[string]uint64
+	err         error
+	mu          sync.Mutex
+}
+
+type Option func(r *Recorder)
+
+// WithMaxFileSize starts a new file once the current one reaches size bytes. Defaults to 16MB.
+func WithMaxFileSize(size int64) Option {
+	util.Require(size > 0, "recorder: size must be positive.")
+
+	return func(r *Recorder) {
+		r.maxFileSize = size
+	}
+}
+
+// WithMaxFileSpan starts a new file once the current one spans d. Defaults to an hour.
+func WithMaxFileSpan(d time.Duration) Option {
+	util.Require(d > 0, "recorder: d must be positive.")
+
+	return func(r *Recorder) {
+		r.maxFileSpan = d
+	}
+}
+
+// WithMaxAge deletes files whose samples are all older than d. By default files are kept.
+func WithMaxAge(d time.Duration) Option {
+	util.Require(d > 0, "recorder: d must be positive.")
+
+	return func(r *Recorder) {
+		r.maxAge = d
+	}
+}
+
+// WithMaxFiles keeps at most n files, deleting the oldest ones. By default files are kept.
+func WithMaxFiles(n int) Option {
+	util.Require(n > 0, "recorder: n must be positive.")
+
+	return func(r *Recorder) {
+		r.maxFiles = n
+	}
+}
+
+// NewRecorder creates a recorder writing to dir, creating it if needed. Existing files are kept,
+// and recording continues in a new file.
+func NewRecorder(dir string, options ...Option) (*Recorder, error) {
+	util.Require(len(dir) > 0, "recorder: dir cannot be empty.")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	recorder := &Recorder{
+		dir:         dir,
+		maxFileSize: defaultFileSize,
+		maxFileSpan: defaultFileSpan,
+	}
+
+	for _, option := range options {
+		option(recorder)
+	}
+
+	return recorder, nil
+}
+
+// Write appends the samples of a tick. Ticks must be written in time order.
+func (self *Recorder) Write(at time.Time, samples []perfcounters.Sample) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.file != nil && (self.size >= self.maxFileSize || at.Sub(self.start) >= self.maxFileSpan) {
+		if err := self.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	if self.file == nil {
+		if err := self.openFile(at); err != nil {
+			return err
+		}
+	}
+
+	type entry struct {
+		id    uint64
+		value float64
+	}
+
+	entries := make([]entry, 0, len(samples))
+
+	for _, sample := range samples {
+		key := sample.Key()
+		id, ok := self.ids[key]
+
+		if !ok {
+			id = uint64(len(self.ids))
+			self.ids[key] = id
+
+			self.writeByte(recordDefine)
+			self.writeUvarint(id)
+			self.writeUvarint(uint64(len(key)))
+			self.write([]byte(key))
+		}
+
+		entries = append(entries, entry{id, sample.Value})
+	}
+
+	self.writeByte(recordSamples)
+	self.writeVarint(at.Sub(self.last).Nanoseconds())
+	self.writeUvarint(uint64(len(entries)))
+
+	for _, e := range entries {
+		self.writeUvarint(e.id)
+
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(e.value))
+		self.write(b[:])
+	}
+
+	self.last = at
+
+	return self.flush()
+}
+
+// flush flushes the current file, returning the first error met while writing to it. A file that
+// failed is closed, so that the next write starts a new one rather than appending to a torn record.
+func (self *Recorder) flush() error {
+
+	err := self.err
+
+	if err == nil {
+		err = self.writer.Flush()
+	}
+
+	if err != nil {
+		self.file.Close()
+		self.file = nil
+		self.writer = nil
+	}
+
+	return err
+}
+
+func (self *Recorder) Close() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.file == nil {
+		return nil
+	}
+
+	return self.closeFile()
+}
+
+func (self *Recorder) openFile(at time.Time) error {
+
+	name := filepath.Join(self.dir, at.UTC().Format(fileTimeLayout)+extension)
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	self.file = file
+	self.writer = bufio.NewWriter(file)
+	self.size = 0
+	self.start = at
+	self.last = at
+	self.ids = make(map[string]uint64)
+	self.err = nil
+
+	self.write([]byte(magic))
+	self.writeByte(version)
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(at.UnixNano()))
+	self.write(b[:])
+
+	if err := self.flush(); err != nil {
+		return err
+	}
+
+	return self.applyRetention(at)
+}
+
+func (self *Recorder) closeFile() error {
+
+	err := self.writer.Flush()
+
+	if closeErr := self.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	self.file = nil
+	self.writer = nil
+
+	return err
+}
+
+// applyRetention deletes the files past the retention limits, never the current one.
+func (self *Recorder) applyRetention(now time.Time) error {
+
+	files, err := listFiles(self.dir)
+
+	// the current file may have been removed from under the recorder.
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	var firstErr error
+
+	for i, file := range files[:len(files)-1] {
+		// a file holds samples until the next one starts.
+		expired := self.maxAge > 0 && now.Sub(files[i+1].start) > self.maxAge
+		excess := self.maxFiles > 0 && len(files)-i > self.maxFiles
+
+		if !expired && !excess {
+			break
+		}
+
+		if err := os.Remove(file.path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// write appends b to the current file, keeping the first error for flush to return.
+func (self *Recorder) write(b []byte) {
+
+	if self.err != nil {
+		return
+	}
+
+	n, err := self.writer.Write(b)
+	self.size += int64(n)
+	self.err = err
+}
+
+func (self *Recorder) writeByte(b byte) {
+	self.write([]byte{b})
+}
+
+func (self *Recorder) writeUvarint(value uint64) {
+	var b [binary.MaxVarintLen64]byte
+	self.write(b[:binary.PutUvarint(b[:], value)])
+}
+
+func (self *Recorder) writeVarint(value int64) {
+	var b [binary.MaxVarintLen64]byte
+	self.write(b[:binary.PutVarint(b[:], value)])
+}
+
+type logFile struct {
+	path  string
+	start time.Time
+}
+
+// listFiles returns the recorded files of dir, oldest first.
+func listFiles(dir string) ([]logFile, error) {
+
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var files []logFile
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() || !strings.HasSuffix(name, extension) {
+			continue
+		}
+
+		start, err := time.Parse(fileTimeLayout, strings.TrimSuffix(name, extension))
+
+		if err != nil {
+			continue
+		}
+
+		files = append(files, logFile{filepath.Join(dir, name), start})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].start.Before(files[j].start)
+	})
+
+	return files, nil
+}
@@ -0,0 +1,184 @@
+package recorder
+
+import (
+	"bufio"
+	"errors"
+	"github.com/israelchen/gomon/perfcounters"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func record(t *testing.T, recorder *Recorder, start time.Time, ticks int) {
+
+	for i := 0; i < ticks; i++ {
+		samples := []perfcounters.Sample{
+			{Name: "foo.totalCalls", Value: float64(i)},
+			{Name: "requests", Labels: []perfcounters.Label{{Name: "route", Value: "/a"}}, Value: float64(i) / 2},
+		}
+
+		if err := recorder.Write(start.Add(time.Duration(i)*time.Minute), samples); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecordAndQuery(t *testing.T) {
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, WithMaxFileSpan(10*time.Minute))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	record(t, recorder, start, 30)
+	recorder.Close()
+
+	if files, _ := listFiles(dir); len(files) != 3 {
+		t.Fatalf("Expected 3 files, got %d.", len(files))
+	}
+
+	points, err := Query(dir, "foo.totalCalls", start.Add(9*time.Minute), start.Add(11*time.Minute))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(points) != 3 || points[0].Value != 9 || points[2].Value != 11 || !points[1].Time.Equal(start.Add(10*time.Minute)) {
+		t.Errorf("Points are different than expected: %v.", points)
+	}
+
+	series, err := Series(dir)
+
+	if err != nil || len(series) != 2 || series[0] != "foo.totalCalls" || series[1] != "requests{route=/a}" {
+		t.Errorf("Series are different than expected: %v (%v).", series, err)
+	}
+
+	if points, _ := Query(dir, "requests{route=/a}", time.Time{}, time.Time{}); len(points) != 30 || points[29].Value != 14.5 {
+		t.Errorf("Expected 30 points up to 14.5, got %v.", points)
+	}
+}
+
+func TestRetentionAndTruncation(t *testing.T) {
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, WithMaxFileSpan(10*time.Minute), WithMaxFiles(2))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	record(t, recorder, start, 30)
+	recorder.Close()
+
+	files, _ := listFiles(dir)
+
+	if len(files) != 2 || !files[0].start.Equal(start.Add(10*time.Minute)) {
+		t.Fatalf("Expected the 2 latest files, got %v.", files)
+	}
+
+	// a torn last record ends the file.
+	info, _ := os.Stat(files[1].path)
+	os.Truncate(files[1].path, info.Size()-3)
+
+	points, err := Query(dir, "foo.totalCalls", time.Time{}, time.Time{})
+
+	if err != nil || len(points) != 19 || points[0].Value != 10 || points[18].Value != 28 {
+		t.Errorf("Points are different than expected: %v (%v).", points, err)
+	}
+
+	// files removed from under the recorder are not an error.
+	for _, file := range files {
+		os.Remove(file.path)
+	}
+
+	if err := recorder.applyRetention(start); err != nil {
+		t.Errorf("Expected no error without files, got %v.", err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteErrors(t *testing.T) {
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer recorder.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	record(t, recorder, start, 1)
+
+	// a write too large for the buffer goes straight to the failing writer.
+	recorder.writer = bufio.NewWriterSize(failingWriter{}, 16)
+
+	if err := recorder.Write(start.Add(time.Minute), []perfcounters.Sample{{Name: "foo.totalCalls", Value: 1}}); err == nil || err.Error() != "disk full" {
+		t.Fatalf("Expected the write error, got %v.", err)
+	}
+
+	// the next write starts a new file.
+	record(t, recorder, start.Add(2*time.Minute), 1)
+
+	if files, _ := listFiles(dir); len(files) != 2 {
+		t.Errorf("Expected a new file after the error, got %v.", files)
+	}
+}
+
+func TestScanDamagedFiles(t *testing.T) {
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, WithMaxFileSpan(10*time.Minute))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	record(t, recorder, start, 20)
+	recorder.Close()
+
+	files, _ := listFiles(dir)
+
+	// garbage after the records of the first file.
+	f, _ := os.OpenFile(files[0].path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte("Xgarbage"))
+	f.Close()
+
+	// a crash right after creating the last file.
+	os.WriteFile(filepath.Join(dir, start.Add(time.Hour).UTC().Format(fileTimeLayout)+extension), []byte(magic[:3]), 0644)
+
+	points, err := Query(dir, "foo.totalCalls", time.Time{}, time.Time{})
+
+	if !errors.Is(err, errCorrupt) || !strings.Contains(err.Error(), files[0].path) {
+		t.Errorf("Expected the first file to be reported corrupt, got %v.", err)
+	}
+
+	if len(points) != 20 {
+		t.Errorf("Expected the points of every file, got %d.", len(points))
+	}
+
+	// zeros left at the end of a file by a crash are not damage.
+	os.Remove(files[0].path)
+
+	f, _ = os.OpenFile(files[1].path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write(make([]byte, 64))
+	f.Close()
+
+	if points, err := Query(dir, "foo.totalCalls", time.Time{}, time.Time{}); err != nil || len(points) != 10 {
+		t.Errorf("Expected the 10 points of the second file, got %d (%v).", len(points), err)
+	}
+}
@@ -0,0 +1,184 @@
+package perfcounters
+
+import (
+	"expvar"
+	"github.com/israelchen/gomon/util"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sample is the value of a registered counter at the time it was sampled. Samples of counters
+// registered through a Collector, such as the series of a CounterVec, carry their labels.
+type Sample struct {
+	Name   string
+	Labels []Label
+	Value  float64
+	Var    expvar.Var
+}
+
+// Key identifies the series of a sample: its name, followed by its labels if any, e.g.
+// "requests{route=/foo,status=200}".
+func (self Sample) Key() string {
+
+	if len(self.Labels) == 0 {
+		return self.Name
+	}
+
+	pairs := make([]string, len(self.Labels))
+
+	for i, label := range self.Labels {
+		pairs[i] = label.Name + "=" + label.Value
+	}
+
+	return self.Name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Collector is implemented by registered vars that expand into several labelled samples.
+type Collector interface {
+	Collect(name string) []Sample
+}
+
+// Registry keeps counters by name so that they can be sampled and exported together. Names are
+// dotted paths mirroring where the counter is published in expvar, e.g. "foo.totalCalls" for the
+// totalCalls entry of the foo map. Counters registered with labels, like the instances of a
+// Category, are kept under the key of their samples, e.g. "disks.bytesPerSec{instance=sda}".
+//
+// Note that sampling reads the counters, and reading the calculated value of a counter starts a
+// new sample interval for it, so the registry should be sampled by a single reader.
+//
+// The calculated counters, rates and averages, keep a second interval for their String method,
+// which expvar calls when /debug/vars is read. Scraping /debug/vars, e.g. with gomon top or gomon
+// record, thus reports the values since the previous scrape and leaves the intervals of the
+// sampler alone.
+type Registry struct {
+	vars map[string]registered
+	mu   sync.RWMutex
+}
+
+type registered struct {
+	name   string
+	labels []Label
+	v      expvar.Var
+}
+
+// DefaultRegistry is the registry the counters published by this module are registered with.
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		vars: make(map[string]registered),
+	}
+}
+
+// Register adds a counter under name. Like expvar.Publish, it panics if the name is already taken.
+func (self *Registry) Register(name string, v expvar.Var) {
+	self.RegisterWithLabels(name, nil, v)
+}
+
+// RegisterWithLabels adds a counter whose samples carry labels. It is registered under the key of
+// its samples, see Sample.Key, and panics if that key is already taken.
+func (self *Registry) RegisterWithLabels(name string, labels []Label, v expvar.Var) {
+	util.Require(len(name) > 0, "perfcounters: name cannot be empty.")
+	util.Require(v != nil, "perfcounters: v cannot be nil.")
+
+	key := Sample{Name: name, Labels: labels}.Key()
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	_, exists := self.vars[key]
+	util.Require(!exists, "perfcounters: "+key+" is already registered.")
+
+	self.vars[key] = registered{name: name, labels: labels, v: v}
+}
+
+// Unregister removes the counter registered under key, a name or the key of a labelled counter.
+func (self *Registry) Unregister(key string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	delete(self.vars, key)
+}
+
+// Get returns the counter registered under key, a name or the key of a labelled counter.
+func (self *Registry) Get(key string) expvar.Var {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return self.vars[key].v
+}
+
+// Names returns the registered names, and the keys of labelled counters, in order.
+func (self *Registry) Names() []string {
+	self.mu.RLock()
+
+	names := make([]string, 0, len(self.vars))
+
+	for name := range self.vars {
+		names = append(names, name)
+	}
+
+	self.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Sample reads every registered counter, in name order, expanding collectors into their samples.
+// Counters whose value is not numeric are left out.
+func (self *Registry) Sample() []Sample {
+
+	names := self.Names()
+	samples := make([]Sample, 0, len(names))
+
+	for _, key := range names {
+		self.mu.RLock()
+		entry, ok := self.vars[key]
+		self.mu.RUnlock()
+
+		if !ok {
+			continue
+		}
+
+		if collector, ok := entry.v.(Collector); ok {
+			samples = append(samples, collector.Collect(entry.name)...)
+			continue
+		}
+
+		if value, ok := SampleValue(entry.v); ok {
+			samples = append(samples, Sample{Name: entry.name, Labels: entry.labels, Value: value, Var: entry.v})
+		}
+	}
+
+	return samples
+}
+
+// SampleValue returns the numeric value of a counter, or of any expvar.Var whose string form is a
+// number.
+func SampleValue(v expvar.Var) (float64, bool) {
+
+	switch counter := v.(type) {
+	case *NumberOfItems32:
+		return float64(counter.Value()), true
+	case *NumberOfItems64:
+		return float64(counter.Value()), true
+	case *expvar.Int:
+		return float64(counter.Value()), true
+	case *expvar.Float:
+		return counter.Value(), true
+	case interface{ CalculatedValue() float64 }:
+		return counter.CalculatedValue(), true
+	case interface{ CalculatedValue() float32 }:
+		return float64(counter.CalculatedValue()), true
+	}
+
+	value, err := strconv.ParseFloat(v.String(), 64)
+	return value, err == nil
+}
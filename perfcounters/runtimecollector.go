@@ -0,0 +1,248 @@
+package perfcounters
+
+import (
+	"expvar"
+	"github.com/israelchen/gomon/clock"
+	"github.com/israelchen/gomon/util"
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	metricGoroutines   = "/sched/goroutines:goroutines"
+	metricHeapObjects  = "/memory/classes/heap/objects:bytes"
+	metricHeapUnused   = "/memory/classes/heap/unused:bytes"
+	metricGCPauses     = "/sched/pauses/total/gc:seconds"
+	metricGCPausesOld  = "/gc/pauses:seconds"
+	metricGCCycles     = "/gc/cycles/total:gc-cycles"
+	metricSchedLatency = "/sched/latencies:seconds"
+	metricCPUTime      = "/cpu/classes/total:cpu-seconds"
+)
+
+// RuntimeCollector periodically populates process health counters from runtime/metrics and, on
+// Linux, from /proc/self. Quantiles are computed over the pauses and scheduling latencies observed
+// since the previous collection.
+//
+// The counters are published in expvar under the collector's name and registered with
+// DefaultRegistry as "<name>.<counter>":
+//
+//	goroutines                             number of live goroutines
+//	heapInUseBytes                         bytes of heap spans in use
+//	gcPauseP50Micros, P90, P99             stop-the-world GC pause quantiles
+//	gcCyclesPerSec                         completed GC cycles per second
+//	schedLatencyP50Micros, P90, P99        time goroutines spent runnable before running
+//	cpuTimeMillis                          CPU time spent by the process, as estimated by the runtime
+//	openFDs, rssBytes                      open file descriptors and resident set size (Linux only)
+type RuntimeCollector struct {
+	interval time.Duration
+	clock    clock.Clock
+	counters map[string]*NumberOfItems64
+	gcCycles *RateOfCountsPerSecond32
+	samples  []metrics.Sample
+	previous map[string][]uint64
+	stop     func()
+	mu       sync.Mutex
+}
+
+var (
+	runtimeQuantiles     = []float64{0.5, 0.9, 0.99}
+	runtimeQuantileNames = []string{"P50", "P90", "P99"}
+)
+
+func NewRuntimeCollector(name string, interval time.Duration) *RuntimeCollector {
+	return NewRuntimeCollectorWithClock(clock.Real, name, interval)
+}
+
+// NewRuntimeCollectorWithClock creates a collector that collects and measures its rates on c.
+func NewRuntimeCollectorWithClock(c clock.Clock, name string, interval time.Duration) *RuntimeCollector {
+	util.Require(c != nil, "perfcounters: c cannot be nil.")
+	util.Require(len(name) > 0, "perfcounters: name cannot be empty.")
+	util.Require(interval > 0, "perfcounters: interval must be positive.")
+
+	collector := &RuntimeCollector{
+		interval: interval,
+		clock:    c,
+		counters: make(map[string]*NumberOfItems64),
+		gcCycles: NewRateOfCountsPerSecond32WithClock(c),
+		previous: make(map[string][]uint64),
+	}
+
+	names := []string{"goroutines", "heapInUseBytes", "cpuTimeMillis"}
+
+	for _, quantile := range runtimeQuantileNames {
+		names = append(names, "gcPause"+quantile+"Micros", "schedLatency"+quantile+"Micros")
+	}
+
+	if _, _, err := readProcSelf(); err == nil {
+		names = append(names, "openFDs", "rssBytes")
+	}
+
+	m := expvar.NewMap(name)
+
+	for _, counterName := range names {
+		collector.counters[counterName] = NewNumberOfItems64()
+		m.Set(counterName, collector.counters[counterName])
+		DefaultRegistry.Register(name+"."+counterName, collector.counters[counterName])
+	}
+
+	m.Set("gcCyclesPerSec", collector.gcCycles)
+	DefaultRegistry.Register(name+".gcCyclesPerSec", collector.gcCycles)
+
+	supported := make(map[string]bool)
+
+	for _, description := range metrics.All() {
+		supported[description.Name] = true
+	}
+
+	for _, metric := range []string{metricGoroutines, metricHeapObjects, metricHeapUnused, metricGCCycles, metricSchedLatency, metricCPUTime} {
+		collector.samples = append(collector.samples, metrics.Sample{Name: metric})
+	}
+
+	if supported[metricGCPauses] {
+		collector.samples = append(collector.samples, metrics.Sample{Name: metricGCPauses})
+	} else {
+		collector.samples = append(collector.samples, metrics.Sample{Name: metricGCPausesOld})
+	}
+
+	collector.Collect()
+
+	return collector
+}
+
+// Start collects every interval until Stop is called.
+func (self *RuntimeCollector) Start() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	util.Require(self.stop == nil, "perfcounters: collector is already started.")
+
+	self.stop = every(self.clock, self.interval, func(now time.Time) {
+		self.Collect()
+	})
+}
+
+func (self *RuntimeCollector) Stop() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.stop != nil {
+		self.stop()
+		self.stop = nil
+	}
+}
+
+// Collect populates the counters once.
+func (self *RuntimeCollector) Collect() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	metrics.Read(self.samples)
+
+	var heapInUse uint64
+
+	for _, sample := range self.samples {
+		switch sample.Name {
+		case metricGoroutines:
+			self.set("goroutines", int64(uint64Value(sample)))
+
+		case metricHeapObjects, metricHeapUnused:
+			heapInUse += uint64Value(sample)
+
+		case metricGCCycles:
+			cycles := uint64Value(sample)
+
+			if previous, ok := self.previous[sample.Name]; ok && cycles > previous[0] {
+				self.gcCycles.Add(int32(cycles - previous[0]))
+			}
+
+			self.previous[sample.Name] = []uint64{cycles}
+
+		case metricCPUTime:
+			if sample.Value.Kind() == metrics.KindFloat64 {
+				self.set("cpuTimeMillis", int64(sample.Value.Float64()*1e3))
+			}
+
+		case metricGCPauses, metricGCPausesOld:
+			self.setQuantiles("gcPause", sample)
+
+		case metricSchedLatency:
+			self.setQuantiles("schedLatency", sample)
+		}
+	}
+
+	self.set("heapInUseBytes", int64(heapInUse))
+
+	if fds, rss, err := readProcSelf(); err == nil {
+		self.set("openFDs", fds)
+		self.set("rssBytes", rss)
+	}
+}
+
+func (self *RuntimeCollector) set(name string, value int64) {
+	if counter, ok := self.counters[name]; ok {
+		counter.Set(value)
+	}
+}
+
+// setQuantiles sets the quantile counters from the observations added to a cumulative histogram
+// since the previous collection, leaving them unchanged when there were none.
+func (self *RuntimeCollector) setQuantiles(prefix string, sample metrics.Sample) {
+
+	if sample.Value.Kind() != metrics.KindFloat64Histogram {
+		return
+	}
+
+	histogram := sample.Value.Float64Histogram()
+	previous := self.previous[sample.Name]
+	counts := make([]uint64, len(histogram.Counts))
+
+	var total uint64
+
+	for i, count := range histogram.Counts {
+		counts[i] = count
+
+		if len(previous) == len(counts) {
+			counts[i] -= previous[i]
+		}
+
+		total += counts[i]
+	}
+
+	self.previous[sample.Name] = append([]uint64(nil), histogram.Counts...)
+
+	if total == 0 {
+		return
+	}
+
+	for i, q := range runtimeQuantiles {
+		rank := uint64(math.Ceil(q * float64(total)))
+		var seen uint64
+
+		for bucket, count := range counts {
+			seen += count
+
+			if seen >= rank {
+				// report the upper bound of the bucket, or its lower bound for the unbounded last one
+				upper := histogram.Buckets[bucket+1]
+
+				if math.IsInf(upper, 1) {
+					upper = histogram.Buckets[bucket]
+				}
+
+				self.set(prefix+runtimeQuantileNames[i]+"Micros", int64(upper*1e6))
+				break
+			}
+		}
+	}
+}
+
+func uint64Value(sample metrics.Sample) uint64 {
+
+	if sample.Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return sample.Value.Uint64()
+}
@@ -0,0 +1,151 @@
+package perfcounters
+
+import (
+	"github.com/israelchen/gomon/clock"
+	"github.com/israelchen/gomon/util"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Sink receives the samples of every sampler tick, e.g. to push them to a monitoring system. The
+// samples are shared between sinks and must not be modified.
+type Sink interface {
+	Write(at time.Time, samples []Sample) error
+}
+
+// Sampler periodically samples a registry and hands the samples to its sinks. Since reading a
+// counter starts a new sample interval for it, a process should have a single sampler per
+// registry, with all exporters attached to it as sinks. Reading the counters through expvar does
+// not count as reading them, see Registry.
+type Sampler struct {
+	registry *Registry
+	interval time.Duration
+	sinks    []Sink
+	onError  func(sink Sink, err error)
+	clock    clock.Clock
+	stop     func()
+	mu       sync.Mutex
+}
+
+type SamplerOption func(s *Sampler)
+
+// WithSink adds a sink to the sampler.
+func WithSink(sink Sink) SamplerOption {
+	util.Require(sink != nil, "perfcounters: sink cannot be nil.")
+
+	return func(s *Sampler) {
+		s.sinks = append(s.sinks, sink)
+	}
+}
+
+// WithSinkErrorHandler sets the function called when a sink fails to write. By default errors are
+// logged with slog.
+func WithSinkErrorHandler(onError func(sink Sink, err error)) SamplerOption {
+	util.Require(onError != nil, "perfcounters: onError cannot be nil.")
+
+	return func(s *Sampler) {
+		s.onError = onError
+	}
+}
+
+// WithSamplerClock sets the clock the sampler ticks on and stamps samples with. Defaults to the
+// real clock.
+func WithSamplerClock(c clock.Clock) SamplerOption {
+	util.Require(c != nil, "perfcounters: c cannot be nil.")
+
+	return func(s *Sampler) {
+		s.clock = c
+	}
+}
+
+func NewSampler(registry *Registry, interval time.Duration, options ...SamplerOption) *Sampler {
+	util.Require(registry != nil, "perfcounters: registry cannot be nil.")
+	util.Require(interval > 0, "perfcounters: interval must be positive.")
+
+	sampler := &Sampler{
+		registry: registry,
+		interval: interval,
+		onError: func(sink Sink, err error) {
+			slog.Warn("perfcounters: sink failed to write samples.", "error", err)
+		},
+		clock: clock.Real,
+	}
+
+	for _, option := range options {
+		option(sampler)
+	}
+
+	return sampler
+}
+
+func (self *Sampler) Interval() time.Duration {
+	return self.interval
+}
+
+// Start samples every interval until Stop is called.
+func (self *Sampler) Start() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	util.Require(self.stop == nil, "perfcounters: sampler is already started.")
+
+	self.stop = every(self.clock, self.interval, self.Tick)
+}
+
+func (self *Sampler) Stop() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.stop != nil {
+		self.stop()
+		self.stop = nil
+	}
+}
+
+// Tick samples the registry once and writes the samples, stamped with at, to every sink in turn.
+func (self *Sampler) Tick(at time.Time) {
+
+	samples := self.registry.Sample()
+
+	for _, sink := range self.sinks {
+		if err := sink.Write(at, samples); err != nil {
+			self.onError(sink, err)
+		}
+	}
+}
+
+// every calls f with the time of c every interval, each call being scheduled once the previous one
+// returned, until the returned function is called.
+func every(c clock.Clock, interval time.Duration, f func(now time.Time)) (stop func()) {
+
+	var timer clock.Timer
+	var stopped bool
+	var mu sync.Mutex
+	var schedule func()
+
+	schedule = func() {
+		timer = c.AfterFunc(interval, func() {
+			f(c.Now())
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if !stopped {
+				schedule()
+			}
+		})
+	}
+
+	mu.Lock()
+	schedule()
+	mu.Unlock()
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		stopped = true
+		timer.Stop()
+	}
+}
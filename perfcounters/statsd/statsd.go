@@ -0,0 +1,239 @@
+// Package statsd pushes perfcounters samples to a StatsD or DogStatsD agent over UDP. An Exporter
+// is a perfcounters.Sink; attach it to a perfcounters.Sampler to push every registered counter on
+// each sampler tick.
+package statsd
+
+import (
+	"bytes"
+	"github.com/israelchen/gomon/perfcounters"
+	"github.com/israelchen/gomon/util"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxPacketSize keeps packets within the MTU of a typical ethernet network, after IP and UDP
+// headers.
+const DefaultMaxPacketSize = 1432
+
+// Exporter writes samples in StatsD line format, batching as many lines per packet as fit in the
+// maximum packet size. The value of each counter type maps to:
+//
+//	NumberOfItems32/64, expvar.Int     a gauge, or a counter of the change since the previous push
+//	                                   with WithCounterDeltas
+//	AverageTimer32                     a timer sample in ms, left out when there were no operations
+//	everything else                    a gauge
+//
+// Labels of counter vectors become tags with WithDogStatsD, and are otherwise appended to the
+// metric name as dotted segments.
+type Exporter struct {
+	conn          net.Conn
+	prefix        string
+	dogStatsD     bool
+	tags          []string
+	counterDeltas bool
+	maxPacketSize int
+	previous      map[string]float64
+	mu            sync.Mutex
+}
+
+type Option func(e *Exporter)
+
+// WithPrefix prepends prefix to every metric name, e.g. "myservice.".
+func WithPrefix(prefix string) Option {
+	return func(e *Exporter) {
+		e.prefix = prefix
+	}
+}
+
+// WithDogStatsD writes labels as DogStatsD tags, and adds the given tags to every metric.
+func WithDogStatsD(tags ...perfcounters.Label) Option {
+	return func(e *Exporter) {
+		e.dogStatsD = true
+
+		for _, tag := range tags {
+			e.tags = append(e.tags, sanitize(tag.Name)+":"+sanitize(tag.Value))
+		}
+	}
+}
+
+// WithCounterDeltas pushes NumberOfItems counters as StatsD counters of the change since the
+// previous push, instead of as gauges. Use it for counters that only grow, like totals of calls.
+//
+// The first push of a series only records its value as the baseline, since the exporter cannot
+// tell how much of it was already counted, e.g. by a previous instance of the process.
+func WithCounterDeltas() Option {
+	return func(e *Exporter) {
+		e.counterDeltas = true
+	}
+}
+
+// WithMaxPacketSize sets the maximum size of a packet. Defaults to DefaultMaxPacketSize.
+func WithMaxPacketSize(size int) Option {
+	util.Require(size > 0, "statsd: size must be positive.")
+
+	return func(e *Exporter) {
+		e.maxPacketSize = size
+	}
+}
+
+// NewExporter creates an exporter sending to the agent at address, e.g. "localhost:8125".
+func NewExporter(address string, options ...Option) (*Exporter, error) {
+	util.Require(len(address) > 0, "statsd: address cannot be empty.")
+
+	conn, err := net.Dial("udp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := &Exporter{
+		conn:          conn,
+		maxPacketSize: DefaultMaxPacketSize,
+		previous:      make(map[string]float64),
+	}
+
+	for _, option := range options {
+		option(exporter)
+	}
+
+	return exporter, nil
+}
+
+// Write sends the samples, returning the first error met while sending packets.
+func (self *Exporter) Write(at time.Time, samples []perfcounters.Sample) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	var packet bytes.Buffer
+	var firstErr error
+
+	// only the series of this push are kept, so that removed and expired series are forgotten and
+	// start over from zero should they come back
+	current := make(map[string]float64, len(self.previous))
+
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+
+		if _, err := self.conn.Write(packet.Bytes()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		packet.Reset()
+	}
+
+	for _, sample := range samples {
+		lines := self.lines(sample, current)
+
+		if len(lines) == 0 {
+			continue
+		}
+
+		// the lines of a sample go in the same packet, so that the two lines of a negative gauge are
+		// not reordered or lost on their own. Lines larger than a packet are still sent, on their own.
+		chunk := strings.Join(lines, "\n")
+
+		if packet.Len() > 0 && packet.Len()+1+len(chunk) > self.maxPacketSize {
+			flush()
+		}
+
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+
+		packet.WriteString(chunk)
+	}
+
+	flush()
+
+	self.previous = current
+
+	return firstErr
+}
+
+// lines formats a sample, as no line, one line or, for negative gauges, two lines, recording the
+// values counter deltas are computed from in current. Callers must hold the lock.
+func (self *Exporter) lines(sample perfcounters.Sample, current map[string]float64) []string {
+
+	if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+		return nil
+	}
+
+	name := self.prefix + sanitize(sample.Name)
+	var tags string
+
+	if self.dogStatsD {
+		all := append([]string(nil), self.tags...)
+
+		for _, label := range sample.Labels {
+			all = append(all, sanitize(label.Name)+":"+sanitize(label.Value))
+		}
+
+		if len(all) > 0 {
+			tags = "|#" + strings.Join(all, ",")
+		}
+	} else {
+		for _, label := range sample.Labels {
+			name += "." + sanitize(label.Value)
+		}
+	}
+
+	value := sample.Value
+
+	switch sample.Var.(type) {
+	case *perfcounters.AverageTimer32:
+		if value == 0 {
+			return nil
+		}
+
+		return []string{name + ":" + formatValue(value) + "|ms" + tags}
+
+	case *perfcounters.NumberOfItems32, *perfcounters.NumberOfItems64, interface{ Value() int64 }:
+		if self.counterDeltas {
+			key := name + tags
+			previous, seen := self.previous[key]
+			current[key] = value
+
+			if !seen || value == previous {
+				return nil
+			}
+
+			delta := value - previous
+
+			return []string{name + ":" + formatValue(delta) + "|c" + tags}
+		}
+	}
+
+	// a signed gauge value is applied by StatsD as a change to the gauge, so negative values are
+	// set by first resetting the gauge to 0.
+	if value < 0 {
+		return []string{name + ":0|g" + tags, name + ":" + formatValue(value) + "|g" + tags}
+	}
+
+	return []string{name + ":" + formatValue(value) + "|g" + tags}
+}
+
+func (self *Exporter) Close() error {
+	return self.conn.Close()
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// sanitize replaces the characters that delimit the parts of a StatsD line.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\t', '\n':
+			return '_'
+		}
+
+		return r
+	}, s)
+}
@@ -0,0 +1,130 @@
+package statsd
+
+import (
+	"expvar"
+	"github.com/israelchen/gomon/perfcounters"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) *net.UDPConn {
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UDPConn) []string {
+
+	var packets []string
+	buffer := make([]byte, 65536)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buffer)
+
+		if err != nil {
+			return packets
+		}
+
+		packets = append(packets, string(buffer[:n]))
+	}
+}
+
+func testSamples() []perfcounters.Sample {
+
+	calls := perfcounters.NewNumberOfItems64()
+	calls.Add(5)
+
+	timer := perfcounters.NewAverageTimer32()
+	timer.Add(10 * time.Millisecond)
+	timer.Add(20 * time.Millisecond)
+
+	queue := perfcounters.NewNumberOfItems64()
+	queue.Set(-2)
+
+	return []perfcounters.Sample{
+		{Name: "foo.totalCalls", Value: 5, Var: calls},
+		{Name: "foo.elapsed", Value: timer.CalculatedValue(), Var: timer},
+		{Name: "foo.queue", Value: -2, Var: queue},
+		{Name: "requests", Labels: []perfcounters.Label{{Name: "route", Value: "/a:b"}}, Value: 1.5, Var: perfcounters.NewAverageCount64()},
+	}
+}
+
+func TestExporter(t *testing.T) {
+
+	conn := listen(t)
+	exporter, err := NewExporter(conn.LocalAddr().String(), WithPrefix("svc."))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer exporter.Close()
+
+	if err := exporter.Write(time.Now(), testSamples()); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := receive(t, conn)
+	expected := "svc.foo.totalCalls:5|g\nsvc.foo.elapsed:15|ms\nsvc.foo.queue:0|g\nsvc.foo.queue:-2|g\nsvc.requests./a_b:1.5|g"
+
+	if len(packets) != 1 || packets[0] != expected {
+		t.Errorf("Packets are different than expected: %q.", packets)
+	}
+}
+
+func TestExporterDogStatsDAndDeltas(t *testing.T) {
+
+	conn := listen(t)
+	exporter, err := NewExporter(conn.LocalAddr().String(), WithDogStatsD(perfcounters.Label{Name: "env", Value: "test"}), WithCounterDeltas(), WithMaxPacketSize(40))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer exporter.Close()
+
+	samples := testSamples()
+	level := perfcounters.Sample{Name: "foo.level", Value: -2, Var: new(expvar.Float)}
+
+	// the first push of a series is its baseline
+	exporter.Write(time.Now(), samples[:1])
+
+	samples[0].Value = 8
+	exporter.Write(time.Now(), []perfcounters.Sample{samples[0], samples[3], level})
+
+	// a series missing from a push is forgotten, and its next push is a new baseline
+	exporter.Write(time.Now(), samples[3:4])
+
+	if len(exporter.previous) != 0 {
+		t.Errorf("Missing series were not forgotten: %v.", exporter.previous)
+	}
+
+	samples[0].Value = 2
+	exporter.Write(time.Now(), samples[:1])
+
+	samples[0].Value = 6
+	exporter.Write(time.Now(), samples[:1])
+
+	// the two lines of the negative gauge exceed the packet size together, and are sent as one packet
+	packets := receive(t, conn)
+	expected := []string{
+		"foo.totalCalls:3|c|#env:test",
+		"requests:1.5|g|#env:test,route:/a_b",
+		"foo.level:0|g|#env:test\nfoo.level:-2|g|#env:test",
+		"requests:1.5|g|#env:test,route:/a_b",
+		"foo.totalCalls:4|c|#env:test",
+	}
+
+	if strings.Join(packets, "\n--\n") != strings.Join(expected, "\n--\n") {
+		t.Errorf("Packets are different than expected: %q.", packets)
+	}
+}
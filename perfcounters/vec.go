@@ -0,0 +1,333 @@
+package perfcounters
+
+import (
+	"encoding/json"
+	"expvar"
+	"github.com/israelchen/gomon/util"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+CounterVec, GaugeVec, TimerVec
+
+Families of counters split by dimensions such as route, status code or tenant. Each distinct, ordered combination of label values is a series backed by one of the existing
+counter types: NumberOfItems64 for counters and gauges, AverageTimer32 for timers.
+
+To protect memory and exporters from unbounded label values, a vector holds at most a fixed number of series; further combinations are all routed to a single series whose
+label values are "__overflow__". Series that were not written to for a number of intervals, counted by how often the vector is sampled through the registry, are
+dropped. Only series written through the vector itself, with Add, Set or Observe, can expire: the counter of a series handed out by WithLabelValues may be kept and
+written to at any time, so that series is kept for the life of the vector.
+
+*/
+
+// OverflowLabelValue is the label value of the series that combinations over the cardinality limit are routed to.
+const OverflowLabelValue = "__overflow__"
+
+const defaultMaxSeries = 1000
+
+type vec struct {
+	labelNames []string
+	options    VecOptions
+	newCounter func() expvar.Var
+	series     map[string]*series
+	overflow   *series
+	tick       int
+	mu         sync.Mutex
+}
+
+type series struct {
+	labelValues []string
+	counter     expvar.Var
+	lastUsed    int
+
+	// pinned series had their counter handed out, and never expire
+	pinned bool
+}
+
+// VecOptions holds the settings of a vector, which VecOption functions change.
+type VecOptions struct {
+	// MaxSeries is the cardinality limit of the vector, excluding the overflow series.
+	MaxSeries int
+
+	// ExpiryIntervals is the number of sample intervals after which unused series are dropped, or 0
+	// for series never to expire.
+	ExpiryIntervals int
+}
+
+type VecOption func(o *VecOptions)
+
+// WithMaxSeries sets the cardinality limit of a vector, excluding the overflow series. Defaults to 1000.
+func WithMaxSeries(max int) VecOption {
+	util.Require(max > 0, "perfcounters: max must be positive.")
+
+	return func(o *VecOptions) {
+		o.MaxSeries = max
+	}
+}
+
+// WithExpiry drops series that were not written through the vector, with Add, Set or Observe, for the given number of sample intervals.
+// Series whose counter was handed out by WithLabelValues never expire, since writes through a kept counter would otherwise be lost. By default
+// series never expire.
+func WithExpiry(intervals int) VecOption {
+	util.Require(intervals > 0, "perfcounters: intervals must be positive.")
+
+	return func(o *VecOptions) {
+		o.ExpiryIntervals = intervals
+	}
+}
+
+func newVec(name string, labelNames []string, newCounter func() expvar.Var, options []VecOption) *vec {
+	util.Require(len(name) > 0, "perfcounters: name cannot be empty.")
+	util.Require(len(labelNames) > 0, "perfcounters: labelNames cannot be empty.")
+
+	v := &vec{
+		labelNames: append([]string(nil), labelNames...),
+		options:    VecOptions{MaxSeries: defaultMaxSeries},
+		newCounter: newCounter,
+		series:     make(map[string]*series),
+	}
+
+	for _, option := range options {
+		option(&v.options)
+	}
+
+	util.Require(v.options.MaxSeries > 0, "perfcounters: MaxSeries must be positive.")
+	util.Require(v.options.ExpiryIntervals >= 0, "perfcounters: ExpiryIntervals cannot be negative.")
+
+	return v
+}
+
+// publishVec makes a vector visible in expvar and DefaultRegistry. It is called with the typed
+// wrapper rather than the inner vec, so that the registered var can be told apart by type.
+func publishVec(name string, v expvar.Var) {
+	expvar.Publish(name, v)
+	DefaultRegistry.Register(name, v)
+}
+
+// with returns the counter of the series with the given label values, pinning the series when the
+// counter is handed out to callers.
+func (self *vec) with(labelValues []string, pin bool) expvar.Var {
+	util.Require(len(labelValues) == len(self.labelNames), "perfcounters: wrong number of label values.")
+
+	key := strings.Join(labelValues, "\xff")
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	s, ok := self.series[key]
+
+	if !ok {
+		if len(self.series) >= self.options.MaxSeries {
+			if self.overflow == nil {
+				values := make([]string, len(self.labelNames))
+
+				for i := range values {
+					values[i] = OverflowLabelValue
+				}
+
+				self.overflow = &series{labelValues: values, counter: self.newCounter()}
+			}
+
+			s = self.overflow
+		} else {
+			s = &series{labelValues: append([]string(nil), labelValues...), counter: self.newCounter()}
+			self.series[key] = s
+		}
+	}
+
+	s.lastUsed = self.tick
+	s.pinned = s.pinned || pin
+
+	return s.counter
+}
+
+// Len returns the number of series, including the overflow series once used.
+func (self *vec) Len() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.overflow != nil {
+		return len(self.series) + 1
+	}
+
+	return len(self.series)
+}
+
+// all returns the series ordered by label values, with the overflow series last.
+func (self *vec) all() []*series {
+	all := make([]*series, 0, len(self.series)+1)
+
+	for _, s := range self.series {
+		all = append(all, s)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+	})
+
+	if self.overflow != nil {
+		all = append(all, self.overflow)
+	}
+
+	return all
+}
+
+// Collect samples every series and ends the current interval, dropping expired series.
+func (self *vec) Collect(name string) []Sample {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	var samples []Sample
+
+	for _, s := range self.all() {
+		value, ok := SampleValue(s.counter)
+
+		if !ok {
+			continue
+		}
+
+		labels := make([]Label, len(self.labelNames))
+
+		for i, labelName := range self.labelNames {
+			labels[i] = Label{labelName, s.labelValues[i]}
+		}
+
+		samples = append(samples, Sample{Name: name, Labels: labels, Value: value, Var: s.counter})
+	}
+
+	if expiry := self.options.ExpiryIntervals; expiry > 0 {
+		for key, s := range self.series {
+			if !s.pinned && self.tick-s.lastUsed >= expiry {
+				delete(self.series, key)
+			}
+		}
+
+		if self.overflow != nil && !self.overflow.pinned && self.tick-self.overflow.lastUsed >= expiry {
+			self.overflow = nil
+		}
+	}
+
+	self.tick += 1
+
+	return samples
+}
+
+// String renders the series as a JSON object keyed by label pairs, e.g. {"route=/foo,status=200": "3"}.
+// Backslashes, commas and equal signs of label values are escaped with a backslash.
+func (self *vec) String() string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	values := make(map[string]json.RawMessage, len(self.series)+1)
+
+	for _, s := range self.all() {
+		pairs := make([]string, len(self.labelNames))
+
+		for i, labelName := range self.labelNames {
+			pairs[i] = labelName + "=" + labelEscaper.Replace(s.labelValues[i])
+		}
+
+		value := s.counter.String()
+
+		if !json.Valid([]byte(value)) {
+			b, _ := json.Marshal(value)
+			value = string(b)
+		}
+
+		values[strings.Join(pairs, ",")] = json.RawMessage(value)
+	}
+
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`)
+
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec creates a vector of counts split by the given labels, published in expvar and
+// DefaultRegistry under name.
+func NewCounterVec(name string, labelNames []string, options ...VecOption) *CounterVec {
+
+	v := &CounterVec{newVec(name, labelNames, func() expvar.Var { return NewNumberOfItems64() }, options)}
+	publishVec(name, v)
+
+	return v
+}
+
+// WithLabelValues returns the counter of the series with the given label values, in label order.
+// The series never expires.
+func (self *CounterVec) WithLabelValues(labelValues ...string) *NumberOfItems64 {
+	return self.with(labelValues, true).(*NumberOfItems64)
+}
+
+// Add adds value to the series with the given label values.
+func (self *CounterVec) Add(value int64, labelValues ...string) {
+	self.with(labelValues, false).(*NumberOfItems64).Add(value)
+}
+
+// Increment adds one to the series with the given label values.
+func (self *CounterVec) Increment(labelValues ...string) {
+	self.Add(1, labelValues...)
+}
+
+type GaugeVec struct {
+	*vec
+}
+
+// NewGaugeVec creates a vector of most recently observed values split by the given labels,
+// published in expvar and DefaultRegistry under name.
+func NewGaugeVec(name string, labelNames []string, options ...VecOption) *GaugeVec {
+
+	v := &GaugeVec{newVec(name, labelNames, func() expvar.Var { return NewNumberOfItems64() }, options)}
+	publishVec(name, v)
+
+	return v
+}
+
+// WithLabelValues returns the gauge of the series with the given label values, in label order.
+// The series never expires.
+func (self *GaugeVec) WithLabelValues(labelValues ...string) *NumberOfItems64 {
+	return self.with(labelValues, true).(*NumberOfItems64)
+}
+
+// Set sets the series with the given label values to value.
+func (self *GaugeVec) Set(value int64, labelValues ...string) {
+	self.with(labelValues, false).(*NumberOfItems64).Set(value)
+}
+
+// Add adds value, which may be negative, to the series with the given label values.
+func (self *GaugeVec) Add(value int64, labelValues ...string) {
+	self.with(labelValues, false).(*NumberOfItems64).Add(value)
+}
+
+type TimerVec struct {
+	*vec
+}
+
+// NewTimerVec creates a vector of average durations split by the given labels, published in
+// expvar and DefaultRegistry under name.
+func NewTimerVec(name string, labelNames []string, options ...VecOption) *TimerVec {
+
+	v := &TimerVec{newVec(name, labelNames, func() expvar.Var { return NewAverageTimer32() }, options)}
+	publishVec(name, v)
+
+	return v
+}
+
+// WithLabelValues returns the timer of the series with the given label values, in label order.
+// The series never expires.
+func (self *TimerVec) WithLabelValues(labelValues ...string) *AverageTimer32 {
+	return self.with(labelValues, true).(*AverageTimer32)
+}
+
+// Observe adds an operation that took d to the series with the given label values.
+func (self *TimerVec) Observe(d time.Duration, labelValues ...string) {
+	self.with(labelValues, false).(*AverageTimer32).Add(d)
+}
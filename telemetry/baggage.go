@@ -0,0 +1,194 @@
+package telemetry
+
+import (
+	"github.com/israelchen/gomon/util"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Limits of the W3C baggage header. Members beyond them are dropped when the header is formatted
+// or parsed.
+const (
+	MaxBaggageMembers = 180
+	MaxBaggageBytes   = 8192
+)
+
+// WithBaggage sets baggage on the telemetry before its handlers are started, typically the baggage
+// received from the caller as parsed by ParseBaggage.
+func WithBaggage(baggage map[string]string) Option {
+
+	for key := range baggage {
+		util.Require(isToken(key), "telemetry: invalid baggage key "+key+".")
+	}
+
+	return func(t *Telemetry) {
+		if t.baggage == nil {
+			t.baggage = make(map[string]string, len(baggage))
+		}
+
+		for key, value := range baggage {
+			t.baggage[key] = value
+		}
+	}
+}
+
+// SetBaggage sets a baggage value, which unlike the values recorded by RecordValue is seen by all
+// the descendants of the telemetry and propagated to other processes by telemetryhttp. Keys must be
+// HTTP tokens. It is ignored once the telemetry ended.
+func (self *Telemetry) SetBaggage(key string, value string) {
+	util.Require(isToken(key), "telemetry: invalid baggage key "+key+".")
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if !self.writable() {
+		return
+	}
+
+	if self.baggage == nil {
+		self.baggage = make(map[string]string)
+	}
+
+	self.baggage[key] = value
+}
+
+// BaggageValue returns the baggage value set on the telemetry or, failing that, on its closest
+// ancestor.
+func (self *Telemetry) BaggageValue(key string) (string, bool) {
+
+	for t := self; t != nil; t = t.parent {
+		t.mu.RLock()
+		value, ok := t.baggage[key]
+		t.mu.RUnlock()
+
+		if ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// Baggage returns the baggage the telemetry sees: the values set on it and on its ancestors, the
+// closest ones taking precedence.
+func (self *Telemetry) Baggage() map[string]string {
+
+	var chain []*Telemetry
+
+	for t := self; t != nil; t = t.parent {
+		chain = append(chain, t)
+	}
+
+	baggage := make(map[string]string)
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		chain[i].mu.RLock()
+
+		for key, value := range chain[i].baggage {
+			baggage[key] = value
+		}
+
+		chain[i].mu.RUnlock()
+	}
+
+	return baggage
+}
+
+// FormatBaggage formats baggage as the value of a W3C baggage header, percent-encoding values.
+// Members are sorted by key, and the ones that would exceed MaxBaggageMembers or MaxBaggageBytes
+// are dropped.
+func FormatBaggage(baggage map[string]string) string {
+
+	keys := make([]string, 0, len(baggage))
+
+	for key := range baggage {
+		if isToken(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+
+	members := 0
+
+	for _, key := range keys {
+		member := key + "=" + url.PathEscape(baggage[key])
+
+		if members > 0 {
+			member = "," + member
+		}
+
+		if members == MaxBaggageMembers || b.Len()+len(member) > MaxBaggageBytes {
+			continue
+		}
+
+		b.WriteString(member)
+		members += 1
+	}
+
+	return b.String()
+}
+
+// ParseBaggage parses the value of a W3C baggage header. Properties are ignored, and so are invalid
+// members and the ones beyond MaxBaggageMembers or MaxBaggageBytes.
+func ParseBaggage(header string) map[string]string {
+
+	baggage := make(map[string]string)
+
+	if len(header) > MaxBaggageBytes {
+		if header[MaxBaggageBytes] == ',' {
+			header = header[:MaxBaggageBytes]
+		} else {
+			// drop the member cut in the middle
+			header = header[:strings.LastIndexByte(header[:MaxBaggageBytes], ',')+1]
+		}
+	}
+
+	for _, member := range strings.Split(header, ",") {
+		if len(baggage) == MaxBaggageMembers {
+			break
+		}
+
+		if i := strings.IndexByte(member, ';'); i >= 0 {
+			member = member[:i]
+		}
+
+		key, value, ok := strings.Cut(member, "=")
+		key = strings.TrimSpace(key)
+
+		if !ok || !isToken(key) {
+			continue
+		}
+
+		value, err := url.PathUnescape(strings.TrimSpace(value))
+
+		if err != nil {
+			continue
+		}
+
+		baggage[key] = value
+	}
+
+	return baggage
+}
+
+// isToken returns whether s is an HTTP token as defined by RFC 7230, which baggage keys must be.
+func isToken(s string) bool {
+
+	if len(s) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
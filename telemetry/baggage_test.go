@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+)
+
+func TestBaggageFlowsToDescendants(t *testing.T) {
+
+	root := NewTelemetryWithOptions(context.Background(), "test.baggage", WithBaggage(map[string]string{"tenant": "acme", "region": "eu"}))
+	nested := NewTelemetry(root, "test.baggage.nested")
+	leaf := NewTelemetry(nested, "test.baggage.leaf")
+
+	// set after the descendants were created, still seen by them
+	root.SetBaggage("user", "42")
+	nested.SetBaggage("region", "us")
+
+	if value, ok := leaf.BaggageValue("region"); !ok || value != "us" {
+		t.Errorf("Expected the closest region, got %q.", value)
+	}
+
+	if baggage := leaf.Baggage(); len(baggage) != 3 || baggage["tenant"] != "acme" || baggage["user"] != "42" {
+		t.Errorf("Baggage is different than expected: %v.", baggage)
+	}
+
+	if baggage := root.Baggage(); baggage["region"] != "eu" {
+		t.Error("Baggage of a child leaked to its parent.")
+	}
+
+	root.Close()
+	root.SetBaggage("late", "1")
+
+	if span := root.Snapshot().Children[0].Children[0]; span.Baggage["region"] != "us" || len(span.Baggage) != 3 {
+		t.Errorf("Snapshot baggage is different than expected: %v.", span.Baggage)
+	}
+}
+
+func TestFormatAndParseBaggage(t *testing.T) {
+
+	header := FormatBaggage(map[string]string{"user": "42", "name": "a b,c;d=%"})
+
+	if header != "name=a%20b%2Cc%3Bd=%25,user=42" {
+		t.Errorf("Header is different than expected: %s.", header)
+	}
+
+	baggage := ParseBaggage(header + ", tenant = acme ;ttl=3, bad key=1,noequals,")
+
+	if len(baggage) != 3 || baggage["name"] != "a b,c;d=%" || baggage["user"] != "42" || baggage["tenant"] != "acme" {
+		t.Errorf("Baggage is different than expected: %v.", baggage)
+	}
+}
+
+func TestBaggageLimits(t *testing.T) {
+
+	many := make(map[string]string)
+
+	for i := 0; i < 2*MaxBaggageMembers; i++ {
+		many[fmt.Sprintf("k%03d", i)] = "v"
+	}
+
+	if header := FormatBaggage(many); strings.Count(header, ",") != MaxBaggageMembers-1 {
+		t.Errorf("Expected %d members, got %d.", MaxBaggageMembers, strings.Count(header, ",")+1)
+	}
+
+	large := map[string]string{"a": strings.Repeat("x", MaxBaggageBytes/2), "b": strings.Repeat("y", MaxBaggageBytes/2), "c": "z"}
+
+	// b does not fit after a, but c does
+	if header := FormatBaggage(large); len(header) > MaxBaggageBytes || !strings.HasSuffix(header, ",c=z") || strings.Contains(header, "b=") {
+		t.Errorf("Large baggage was not limited: %d bytes.", len(header))
+	}
+
+	header := "a=" + strings.Repeat("x", MaxBaggageBytes-10) + ",b=" + strings.Repeat("y", 20)
+
+	if baggage := ParseBaggage(header); len(baggage) != 1 || len(baggage["a"]) != MaxBaggageBytes-10 {
+		t.Errorf("Expected the member beyond the limit to be dropped, got %d members.", len(baggage))
+	}
+
+	if baggage := ParseBaggage(FormatBaggage(many) + ",z=1"); len(baggage) != MaxBaggageMembers {
+		t.Errorf("Expected %d members, got %d.", MaxBaggageMembers, len(baggage))
+	}
+}
@@ -0,0 +1,120 @@
+package telemetry
+
+import (
+	"errors"
+	"github.com/israelchen/gomon/util"
+	"golang.org/x/net/context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// ErrorClassifier maps an error to the name of the class it is counted under. Classes become part
+// of counter names, so they should be few and made of letters, digits and underscores; other
+// classes are counted as OtherErrorClass.
+type ErrorClassifier func(err error) string
+
+type errorClass struct {
+	target error
+	class  string
+}
+
+var (
+	errorClasses = []errorClass{
+		{io.EOF, "EOF"},
+		{io.ErrUnexpectedEOF, "unexpectedEOF"},
+		{net.ErrClosed, "closed"},
+		{os.ErrNotExist, "notExist"},
+		{os.ErrPermission, "permission"},
+		{syscall.ECONNREFUSED, "connectionRefused"},
+		{syscall.ECONNRESET, "connectionReset"},
+		{syscall.EPIPE, "brokenPipe"},
+	}
+
+	errorClassesMu sync.RWMutex
+)
+
+// RegisterErrorClass makes DefaultErrorClassifier count errors matching target, as reported by
+// errors.Is, under class. Registered errors are checked in order, after cancellation, deadlines
+// and timeouts, and the ones registered last take precedence.
+//
+// The registration applies to every PerfHandler using DefaultErrorClassifier; use WithErrorClass
+// to add a class to a single handler.
+func RegisterErrorClass(target error, class string) {
+	util.Require(target != nil, "telemetry: target cannot be nil.")
+	util.Require(isErrorClass(class), "telemetry: invalid error class "+class+".")
+
+	errorClassesMu.Lock()
+	defer errorClassesMu.Unlock()
+
+	errorClasses = append([]errorClass{{target, class}}, errorClasses...)
+}
+
+// DefaultErrorClassifier recognises cancellation, deadlines, timeouts, DNS failures, common io, os
+// and connection errors and the errors registered with RegisterErrorClass anywhere in the error
+// chain. Any other error is classified as OtherErrorClass, so that arbitrary error messages never
+// end up in counter names.
+func DefaultErrorClassifier(err error) string {
+	util.Require(err != nil, "telemetry: err cannot be nil.")
+
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "deadlineExceeded"
+	}
+
+	var netErr net.Error
+
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+
+	errorClassesMu.RLock()
+	defer errorClassesMu.RUnlock()
+
+	if class, ok := matchErrorClass(errorClasses, err); ok {
+		return class
+	}
+
+	var dnsErr *net.DNSError
+
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+
+	return OtherErrorClass
+}
+
+// matchErrorClass returns the class of the first of classes whose target err matches.
+func matchErrorClass(classes []errorClass, err error) (string, bool) {
+
+	for _, c := range classes {
+		if errors.Is(err, c.target) {
+			return c.class, true
+		}
+	}
+
+	return "", false
+}
+
+// isErrorClass returns whether class is made of letters, digits and underscores only.
+func isErrorClass(class string) bool {
+
+	if len(class) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(class); i++ {
+		c := class[i]
+
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
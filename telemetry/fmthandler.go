@@ -3,24 +3,160 @@ package telemetry
 import (
 	"fmt"
 	"github.com/israelchen/gomon/util"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 )
 
-type FmtHandler struct{}
+// FmtRecord is what FmtHandler formats for each telemetry start and end. Span is only set for ends.
+type FmtRecord struct {
+	Started bool
+	Span    *SpanData
+	Name    string
+	ID      uint64
+	TraceID TraceID
+	SpanID  SpanID
+	Elapsed time.Duration
+	Error   error
+}
+
+// FmtHandler prints telemetry starts and ends. Its zero value prints every start and end to
+// stdout; the fields below change where and what it prints.
+type FmtHandler struct {
+	// Writer receives the output. Defaults to os.Stdout.
+	Writer io.Writer
+
+	// Format formats a record into a line. Takes precedence over Template.
+	Format func(record *FmtRecord) string
+
+	// Template formats a record into a line when Format is not set.
+	Template *template.Template
+
+	// SkipStarted stops printing telemetry starts.
+	SkipStarted bool
+
+	// ErrorsOnly only prints ends of failed telemetries.
+	ErrorsOnly bool
+
+	// MinDuration only prints ends of telemetries that took at least as long, or failed.
+	MinDuration time.Duration
+
+	// PrintData prints the recorded values of ended telemetries, one per line.
+	PrintData bool
+
+	// PrintTree prints the tree of ended telemetries as rendered by TreeHandler.
+	PrintTree bool
+
+	mu sync.Mutex
+}
 
 func (handler *FmtHandler) Started(telemetry *Telemetry) {
 	util.Require(telemetry != nil, "telemetry: telemetry cannot be nil.")
 
-	fmt.Printf("Telemetry %s started.\n", telemetry.name)
+	if handler.SkipStarted {
+		return
+	}
+
+	handler.print(handler.format(&FmtRecord{
+		Started: true,
+		Name:    telemetry.Name(),
+		ID:      telemetry.ID(),
+		TraceID: telemetry.TraceID(),
+		SpanID:  telemetry.SpanID(),
+	}))
 }
 
-func (handler *FmtHandler) Ended(telemetry *Telemetry) {
-	util.Require(telemetry != nil, "telemetry: telemetry cannot be nil.")
+func (handler *FmtHandler) Ended(span *SpanData) {
+	util.Require(span != nil, "telemetry: span cannot be nil.")
+
+	record := newFmtRecord(span)
+
+	if record.Error == nil && (handler.ErrorsOnly || record.Elapsed < handler.MinDuration) {
+		return
+	}
+
+	var b strings.Builder
 
-	elapsed := telemetry.EndTime().Sub(*telemetry.StartTime())
+	b.WriteString(handler.format(record))
 
-	if telemetry.Error() == nil {
-		fmt.Printf("Telemetry %s ended. Elapsed: %v.\n", telemetry.Name(), elapsed)
-	} else {
-		fmt.Printf("Telemetry %s ended. Elapsed: %v, Error: %s\n", telemetry.Name(), elapsed, telemetry.Error())
+	if handler.PrintData {
+		var data []string
+
+		for k, v := range span.Data {
+			data = append(data, fmt.Sprintf("  %v=%v\n", k, v))
+		}
+
+		sort.Strings(data)
+		b.WriteString(strings.Join(data, ""))
+	}
+
+	if handler.PrintTree {
+		WriteTreeText(&b, AnalyzeTree(span, 0))
 	}
+
+	handler.print(b.String())
+}
+
+func newFmtRecord(span *SpanData) *FmtRecord {
+	return &FmtRecord{
+		Span:    span,
+		Name:    span.Name,
+		ID:      span.ID,
+		TraceID: span.TraceID,
+		SpanID:  span.SpanID,
+		Elapsed: span.Elapsed(),
+		Error:   span.Err,
+	}
+}
+
+func (handler *FmtHandler) format(record *FmtRecord) string {
+
+	var line string
+
+	switch {
+	case handler.Format != nil:
+		line = handler.Format(record)
+
+	case handler.Template != nil:
+		var b strings.Builder
+
+		if err := handler.Template.Execute(&b, record); err != nil {
+			b.WriteString(fmt.Sprintf("telemetry: template error: %s", err))
+		}
+
+		line = b.String()
+
+	case record.Started:
+		line = fmt.Sprintf("Telemetry %s started.", record.Name)
+
+	case record.Error == nil:
+		line = fmt.Sprintf("Telemetry %s ended. Elapsed: %v.", record.Name, record.Elapsed)
+
+	default:
+		line = fmt.Sprintf("Telemetry %s ended. Elapsed: %v, Error: %s", record.Name, record.Elapsed, record.Error)
+	}
+
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+
+	return line
+}
+
+func (handler *FmtHandler) print(output string) {
+
+	writer := handler.Writer
+
+	if writer == nil {
+		writer = os.Stdout
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+
+	io.WriteString(writer, output)
 }
@@ -0,0 +1,66 @@
+package telemetry
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestFmtHandlerDefaultFormat(t *testing.T) {
+
+	var b bytes.Buffer
+
+	ctx := NewTelemetry(context.Background(), "test.fmt", &FmtHandler{Writer: &b})
+	ctx.SetError(errors.New("boom"))
+	ctx.Close()
+
+	lines := strings.Split(b.String(), "\n")
+
+	if lines[0] != "Telemetry test.fmt started." || !strings.HasPrefix(lines[1], "Telemetry test.fmt ended. Elapsed: ") || !strings.HasSuffix(lines[1], ", Error: boom") {
+		t.Errorf("Output is different than expected:\n%s", b.String())
+	}
+}
+
+func TestFmtHandlerFiltersAndFormats(t *testing.T) {
+
+	var b bytes.Buffer
+
+	handler := &FmtHandler{
+		Writer:      &b,
+		Template:    template.Must(template.New("").Parse("{{.Name}} {{if .Error}}failed: {{.Error}}{{else}}ok{{end}}")),
+		SkipStarted: true,
+		MinDuration: time.Hour,
+		PrintData:   true,
+	}
+
+	fast := NewTelemetry(context.Background(), "test.fmt.fast", handler)
+	fast.Close()
+
+	failed := NewTelemetry(context.Background(), "test.fmt.failed", handler)
+	failed.RecordValue("b", 2)
+	failed.RecordValue("a", 1)
+	failed.SetError(errors.New("boom"))
+	failed.Close()
+
+	if b.String() != "test.fmt.failed failed: boom\n  a=1\n  b=2\n" {
+		t.Errorf("Output is different than expected:\n%s", b.String())
+	}
+
+	b.Reset()
+	handler.Format = func(record *FmtRecord) string { return record.Name + " via format" }
+	handler.MinDuration = 0
+	handler.PrintData = false
+	handler.PrintTree = true
+
+	root := NewTelemetry(context.Background(), "test.fmt.root", handler)
+	NewTelemetry(root, "test.fmt.nested")
+	root.Close()
+
+	if !strings.HasPrefix(b.String(), "test.fmt.root via format\n* test.fmt.root ") || !strings.Contains(b.String(), "  test.fmt.nested ") {
+		t.Errorf("Output is different than expected:\n%s", b.String())
+	}
+}
@@ -0,0 +1,57 @@
+package telemetry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// TraceID identifies a tree of telemetries. Nested telemetries share the trace ID of their root.
+// It uses the 16 byte layout of the W3C Trace Context specification.
+type TraceID [16]byte
+
+// SpanID identifies a single telemetry within its trace, using the W3C Trace Context layout.
+type SpanID [8]byte
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return id
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encodes the ID as hex, so that it appears as a string in JSON.
+func (id TraceID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// MarshalText encodes the ID as hex, so that it appears as a string in JSON.
+func (id SpanID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// SpanContext identifies a telemetry outside of its process or tree, e.g. when carried by a
+// message to the telemetry processing it.
+type SpanContext struct {
+	TraceID TraceID `json:"traceId"`
+	SpanID  SpanID  `json:"spanId"`
+}
+
+// IsValid returns whether both IDs are set, as all-zero IDs are invalid in W3C Trace Context.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+func (sc SpanContext) String() string {
+	return sc.TraceID.String() + "-" + sc.SpanID.String()
+}
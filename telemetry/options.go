@@ -0,0 +1,62 @@
+package telemetry
+
+import (
+	"github.com/israelchen/gomon/clock"
+	"github.com/israelchen/gomon/util"
+)
+
+// Option configures a telemetry created by NewTelemetryWithOptions.
+type Option func(t *Telemetry)
+
+func WithHandlers(handlers ...Handler) Option {
+	return func(t *Telemetry) {
+		t.handlers = append(t.handlers, handlers...)
+	}
+}
+
+// Limits bound the memory held by a telemetry, which matters for long-lived roots such as worker
+// loops or streams that keep creating nested telemetries. Zero means unlimited.
+type Limits struct {
+	// MaxChildren is the maximum number of children attached at once. Further children still work
+	// but are not attached, and are counted by the parent's DroppedChildren.
+	MaxChildren int
+
+	// MaxData is the maximum number of distinct keys recorded by RecordValue. Values for further
+	// keys are counted by DroppedData.
+	MaxData int
+
+	// MaxEvents is the maximum number of events recorded by AddEvent. Further events are counted
+	// by DroppedEvents.
+	MaxEvents int
+
+	// MaxLinks is the maximum number of links added by AddLink. Further links are counted by
+	// DroppedLinks.
+	MaxLinks int
+
+	// DetachClosedChildren removes a child from its parent once it has been closed on its own and
+	// delivered to its handlers. Such children will not appear in the parent's tree.
+	DetachClosedChildren bool
+}
+
+// WithLimits sets the limits of the telemetry. Nested telemetries inherit their parent's limits
+// unless they are given their own.
+func WithLimits(limits Limits) Option {
+	util.Require(limits.MaxChildren >= 0, "telemetry: MaxChildren cannot be negative.")
+	util.Require(limits.MaxData >= 0, "telemetry: MaxData cannot be negative.")
+	util.Require(limits.MaxEvents >= 0, "telemetry: MaxEvents cannot be negative.")
+	util.Require(limits.MaxLinks >= 0, "telemetry: MaxLinks cannot be negative.")
+
+	return func(t *Telemetry) {
+		t.limits = limits
+	}
+}
+
+// WithClock sets the clock reading the start, end and event times of the telemetry. Nested
+// telemetries inherit their parent's clock unless they are given their own.
+func WithClock(c clock.Clock) Option {
+	util.Require(c != nil, "telemetry: c cannot be nil.")
+
+	return func(t *Telemetry) {
+		t.clock = c
+	}
+}
@@ -4,34 +4,117 @@ import (
 	"expvar"
 	"github.com/israelchen/gomon/perfcounters"
 	"github.com/israelchen/gomon/util"
+	"sync"
 )
 
+// OtherErrorClass is the class of errors that no class was found for, of invalid classes, and of
+// failures once the handler tracks the maximum number of error classes.
+const OtherErrorClass = "other"
+
+const defaultMaxErrorClasses = 32
+
+// Sizer is implemented by telemetry results that know their size in bytes, e.g. a response body.
+type Sizer interface {
+	Size() int64
+}
+
+// Counter is implemented by telemetry results that know how many items they hold, e.g. rows fetched.
+type Counter interface {
+	Count() int64
+}
+
 type PerfHandler struct {
 	totalCalls      *perfcounters.NumberOfItems32
 	successfulCalls *perfcounters.NumberOfItems32
 	failedCalls     *perfcounters.NumberOfItems32
 	callsPerSec     *perfcounters.RateOfCountsPerSecond32
 	callLatency     *perfcounters.AverageTimer32
+	bytesPerCall    *perfcounters.AverageCount64
+	itemsPerCall    *perfcounters.AverageCount64
+
+	name            string
+	classifier      ErrorClassifier
+	classes         []errorClass
+	maxErrorClasses int
+	errorClasses    map[string]*perfcounters.NumberOfItems32
+	errorClassesVar *expvar.Map
+	mu              sync.Mutex
+}
+
+type PerfHandlerOption func(handler *PerfHandler)
+
+// WithErrorClassifier replaces DefaultErrorClassifier for breaking down failed calls.
+func WithErrorClassifier(classifier ErrorClassifier) PerfHandlerOption {
+	util.Require(classifier != nil, "telemetry: classifier cannot be nil.")
+
+	return func(handler *PerfHandler) {
+		handler.classifier = classifier
+	}
 }
 
-func NewPerfHandler(telemetryName string) *PerfHandler {
+// WithErrorClass counts the failures matching target, as reported by errors.Is, under class. These
+// classes are checked before the classifier, and the ones given last take precedence.
+func WithErrorClass(target error, class string) PerfHandlerOption {
+	util.Require(target != nil, "telemetry: target cannot be nil.")
+	util.Require(isErrorClass(class), "telemetry: invalid error class "+class+".")
+
+	return func(handler *PerfHandler) {
+		handler.classes = append([]errorClass{{target, class}}, handler.classes...)
+	}
+}
+
+// WithMaxErrorClasses caps the number of distinct error classes tracked, including OtherErrorClass.
+func WithMaxErrorClasses(max int) PerfHandlerOption {
+	util.Require(max > 0, "telemetry: max must be positive.")
+
+	return func(handler *PerfHandler) {
+		handler.maxErrorClasses = max
+	}
+}
+
+func NewPerfHandler(telemetryName string, options ...PerfHandlerOption) *PerfHandler {
 	util.Require(len(telemetryName) > 0, "telemetry: telemetryName cannot be empty.")
 
 	handler := &PerfHandler{
+		name:            telemetryName,
 		totalCalls:      perfcounters.NewNumberOfItems32(),
 		successfulCalls: perfcounters.NewNumberOfItems32(),
 		failedCalls:     perfcounters.NewNumberOfItems32(),
 		callsPerSec:     perfcounters.NewRateOfCountsPerSecond32(),
 		callLatency:     perfcounters.NewAverageTimer32(),
+		bytesPerCall:    perfcounters.NewAverageCount64(),
+		itemsPerCall:    perfcounters.NewAverageCount64(),
+		classifier:      DefaultErrorClassifier,
+		maxErrorClasses: defaultMaxErrorClasses,
+		errorClasses:    make(map[string]*perfcounters.NumberOfItems32),
+		errorClassesVar: new(expvar.Map).Init(),
+	}
+
+	for _, option := range options {
+		option(handler)
 	}
 
 	m := expvar.NewMap(telemetryName)
 
-	m.Set("totalCalls", handler.totalCalls)
-	m.Set("successfulCalls", handler.successfulCalls)
-	m.Set("failedCalls", handler.failedCalls)
-	m.Set("callsPerSec", handler.callsPerSec)
-	m.Set("callLatency", handler.callLatency)
+	counters := []struct {
+		name string
+		v    expvar.Var
+	}{
+		{"totalCalls", handler.totalCalls},
+		{"successfulCalls", handler.successfulCalls},
+		{"failedCalls", handler.failedCalls},
+		{"callsPerSec", handler.callsPerSec},
+		{"callLatency", handler.callLatency},
+		{"bytesPerCall", handler.bytesPerCall},
+		{"itemsPerCall", handler.itemsPerCall},
+	}
+
+	for _, counter := range counters {
+		m.Set(counter.name, counter.v)
+		perfcounters.DefaultRegistry.Register(telemetryName+"."+counter.name, counter.v)
+	}
+
+	m.Set("failedCallsByClass", handler.errorClassesVar)
 
 	return handler
 }
@@ -43,16 +126,63 @@ func (self *PerfHandler) Started(t *Telemetry) {
 	self.callsPerSec.Increment()
 }
 
-func (self *PerfHandler) Ended(t *Telemetry) {
-	util.Require(t != nil, "telemetry: telemetry cannot be nil.")
-	util.Require(t.EndTime() != nil, "telemetry: endTime cannot be nil. This handler should be invoked on telemetry end operation only.")
+func (self *PerfHandler) Ended(span *SpanData) {
+	util.Require(span != nil, "telemetry: span cannot be nil.")
+	util.Require(span.Ended, "telemetry: span has not ended. This handler should be invoked on telemetry end operation only.")
 
-	if t.Error() == nil {
+	if span.Err == nil {
 		self.successfulCalls.Increment()
 	} else {
 		self.failedCalls.Increment()
+		class, ok := matchErrorClass(self.classes, span.Err)
+
+		if !ok {
+			class = self.classifier(span.Err)
+		}
+
+		self.errorClass(class).Increment()
 	}
 
-	elapsed := t.StartTime().Sub(*t.EndTime())
-	self.callLatency.Add(elapsed)
+	self.callLatency.Add(span.Elapsed())
+
+	if sizer, ok := span.Result.(Sizer); ok {
+		self.bytesPerCall.Add(sizer.Size())
+	}
+
+	if counter, ok := span.Result.(Counter); ok {
+		self.itemsPerCall.Add(counter.Count())
+	}
+}
+
+func (self *PerfHandler) errorClass(class string) *perfcounters.NumberOfItems32 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	// classes end up in counter names, which custom classifiers might not have been careful about
+	if !isErrorClass(class) {
+		class = OtherErrorClass
+	}
+
+	counter, ok := self.errorClasses[class]
+
+	if ok {
+		return counter
+	}
+
+	// keep the last slot for the overflow class so the classes always add up to failedCalls
+	if len(self.errorClasses) >= self.maxErrorClasses-1 {
+		class = OtherErrorClass
+
+		if counter, ok := self.errorClasses[class]; ok {
+			return counter
+		}
+	}
+
+	counter = perfcounters.NewNumberOfItems32()
+
+	self.errorClasses[class] = counter
+	self.errorClassesVar.Set(class, counter)
+	perfcounters.DefaultRegistry.Register(self.name+".failedCallsByClass."+class, counter)
+
+	return counter
 }
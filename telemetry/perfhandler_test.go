@@ -0,0 +1,136 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"net"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+var _ net.Error = &timeoutError{}
+
+func TestDefaultErrorClassifier(t *testing.T) {
+
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{context.Canceled, "canceled"},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), "deadlineExceeded"},
+		{&net.OpError{Op: "dial", Err: &timeoutError{}}, "timeout"},
+		{fmt.Errorf("read body: %w", fmt.Errorf("decode: %w", io.EOF)), "EOF"},
+		{&net.DNSError{Err: "no such host", Name: "db"}, "dns"},
+		{errors.New("boom"), OtherErrorClass},
+		{fmt.Errorf("user %q: invalid token: %w", "jane@example.com", errors.New("expired at 12:00")), OtherErrorClass},
+	}
+
+	for _, test := range tests {
+		if class := DefaultErrorClassifier(test.err); class != test.expected {
+			t.Errorf("Expected class %q for %v, got %q.", test.expected, test.err, class)
+		}
+	}
+}
+
+func TestRegisterErrorClass(t *testing.T) {
+
+	errorClassesMu.RLock()
+	registered := errorClasses
+	errorClassesMu.RUnlock()
+
+	t.Cleanup(func() {
+		errorClassesMu.Lock()
+		errorClasses = registered
+		errorClassesMu.Unlock()
+	})
+
+	errQuota := errors.New("quota exceeded for tenant 42")
+	RegisterErrorClass(errQuota, "quota")
+
+	if class := DefaultErrorClassifier(fmt.Errorf("upload: %w", errQuota)); class != "quota" {
+		t.Errorf("Expected class quota, got %q.", class)
+	}
+}
+
+func TestWithErrorClass(t *testing.T) {
+
+	errQuota := errors.New("quota exceeded")
+	handler := NewPerfHandler("test.perfhandler.withclass", WithErrorClass(errQuota, "quota"), WithErrorClass(io.EOF, "truncated"))
+
+	for _, err := range []error{fmt.Errorf("upload: %w", errQuota), io.EOF, context.Canceled} {
+		ctx := NewTelemetry(context.Background(), "test.telemetry", handler)
+		ctx.SetError(err)
+		ctx.Close()
+	}
+
+	for _, class := range []string{"quota", "truncated", "canceled"} {
+		if counter := handler.errorClasses[class]; counter == nil || counter.String() != "1" {
+			t.Errorf("Expected 1 failure of class %q, got %v.", class, counter)
+		}
+	}
+
+	if class := DefaultErrorClassifier(errQuota); class != OtherErrorClass {
+		t.Errorf("The handler class leaked into DefaultErrorClassifier: %q.", class)
+	}
+}
+
+func TestPerfHandlerCountsErrorClasses(t *testing.T) {
+
+	handler := NewPerfHandler("test.perfhandler.classes", WithMaxErrorClasses(3))
+
+	for _, err := range []error{nil, io.EOF, io.EOF, context.Canceled, errors.New("a"), errors.New("b")} {
+		ctx := NewTelemetry(context.Background(), "test.telemetry", handler)
+		ctx.SetError(err)
+		ctx.Close()
+	}
+
+	if handler.failedCalls.String() != "5" {
+		t.Errorf("Expected 5 failed calls, got %s.", handler.failedCalls)
+	}
+
+	expected := map[string]string{"EOF": "2", "canceled": "1", OtherErrorClass: "2"}
+
+	if len(handler.errorClasses) != len(expected) {
+		t.Fatalf("Expected %d error classes, got %d.", len(expected), len(handler.errorClasses))
+	}
+
+	for class, count := range expected {
+		if counter := handler.errorClasses[class]; counter == nil || counter.String() != count {
+			t.Errorf("Expected %s failures of class %q, got %v.", count, class, counter)
+		}
+	}
+}
+
+type rows []string
+
+func (r rows) Count() int64 { return int64(len(r)) }
+
+type body []byte
+
+func (b body) Size() int64 { return int64(len(b)) }
+
+func TestPerfHandlerMeasuresResults(t *testing.T) {
+
+	handler := NewPerfHandler("test.perfhandler.results")
+
+	for _, result := range []interface{}{rows{"a", "b"}, rows{"c", "d", "e", "f"}, body("hello"), "ignored"} {
+		ctx := NewTelemetry(context.Background(), "test.telemetry", handler)
+		ctx.SetResult(result)
+		ctx.Close()
+	}
+
+	if handler.itemsPerCall.String() != "3.000" {
+		t.Errorf("Expected 3 items per call, got %s.", handler.itemsPerCall)
+	}
+
+	if handler.bytesPerCall.String() != "5.000" {
+		t.Errorf("Expected 5 bytes per call, got %s.", handler.bytesPerCall)
+	}
+}
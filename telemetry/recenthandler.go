@@ -0,0 +1,297 @@
+package telemetry
+
+import (
+	"fmt"
+	"github.com/israelchen/gomon/util"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RecentBuckets are the minimum latencies by which RecentHandler buckets completed telemetries.
+var RecentBuckets = []time.Duration{0, 10 * time.Millisecond, 100 * time.Millisecond, time.Second, 10 * time.Second, 100 * time.Second}
+
+const defaultRecentCapacity = 10
+
+// RecentHandler keeps, per telemetry name, the last completed trees in each of RecentBuckets
+// along with the last errored ones, in the spirit of golang.org/x/net/trace. A tree is kept in
+// every bucket whose latency it reached. It is an http.Handler serving a page, typically mounted
+// on /debug/telemetry/recent, that drills down from the buckets to each kept tree's data and events.
+type RecentHandler struct {
+	capacity int
+	families map[string]*recentFamily
+	mu       sync.Mutex
+}
+
+type recentFamily struct {
+	buckets []*ring
+	errors  *ring
+}
+
+// ring is a fixed-size buffer of the most recently added snapshots.
+type ring struct {
+	items []*SpanData
+	next  int
+}
+
+func newRing(capacity int) *ring {
+	return &ring{
+		items: make([]*SpanData, 0, capacity),
+	}
+}
+
+func (self *ring) add(span *SpanData) {
+
+	if len(self.items) < cap(self.items) {
+		self.items = append(self.items, span)
+		return
+	}
+
+	self.items[self.next] = span
+	self.next = (self.next + 1) % len(self.items)
+}
+
+// newestFirst returns the items, most recently added first.
+func (self *ring) newestFirst() []*SpanData {
+
+	items := make([]*SpanData, 0, len(self.items))
+
+	for i := 1; i <= len(self.items); i++ {
+		items = append(items, self.items[(self.next-i+len(self.items))%len(self.items)])
+	}
+
+	return items
+}
+
+// NewRecentHandler creates a handler keeping the last capacity trees per bucket, or a default
+// when capacity is 0.
+func NewRecentHandler(capacity int) *RecentHandler {
+	util.Require(capacity >= 0, "telemetry: capacity cannot be negative.")
+
+	if capacity == 0 {
+		capacity = defaultRecentCapacity
+	}
+
+	return &RecentHandler{
+		capacity: capacity,
+		families: make(map[string]*recentFamily),
+	}
+}
+
+func (self *RecentHandler) Started(t *Telemetry) {
+	util.Require(t != nil, "telemetry: telemetry cannot be nil.")
+}
+
+func (self *RecentHandler) Ended(span *SpanData) {
+	util.Require(span != nil, "telemetry: span cannot be nil.")
+
+	elapsed := span.Elapsed()
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	family, ok := self.families[span.Name]
+
+	if !ok {
+		family = &recentFamily{
+			errors: newRing(self.capacity),
+		}
+
+		for range RecentBuckets {
+			family.buckets = append(family.buckets, newRing(self.capacity))
+		}
+
+		self.families[span.Name] = family
+	}
+
+	for i, min := range RecentBuckets {
+		if elapsed >= min {
+			family.buckets[i].add(span)
+		}
+	}
+
+	if span.Err != nil {
+		family.errors.add(span)
+	}
+}
+
+// Recent returns the kept trees of the named telemetry in the given bucket of RecentBuckets,
+// most recent first.
+func (self *RecentHandler) Recent(name string, bucket int) []*SpanData {
+	util.Require(bucket >= 0 && bucket < len(RecentBuckets), "telemetry: bucket is out of range.")
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if family, ok := self.families[name]; ok {
+		return family.buckets[bucket].newestFirst()
+	}
+
+	return nil
+}
+
+// RecentErrors returns the kept errored trees of the named telemetry, most recent first.
+func (self *RecentHandler) RecentErrors(name string) []*SpanData {
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if family, ok := self.families[name]; ok {
+		return family.errors.newestFirst()
+	}
+
+	return nil
+}
+
+func (self *RecentHandler) find(id uint64) *SpanData {
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for _, family := range self.families {
+		for _, span := range family.errors.items {
+			if span.ID == id {
+				return span
+			}
+		}
+
+		for _, r := range family.buckets {
+			for _, span := range r.items {
+				if span.ID == id {
+					return span
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+type recentSummary struct {
+	Name    string
+	Buckets []int
+	Errors  int
+}
+
+type recentEvent struct {
+	Offset     time.Duration
+	Name       string
+	Attributes []string
+}
+
+type recentNode struct {
+	trackerNode
+	Offset        time.Duration
+	Events        []recentEvent
+	DroppedEvents int64
+	DroppedLinks  int64
+	Children      []*recentNode
+}
+
+func newRecentNode(span *SpanData, rootStart time.Time) *recentNode {
+
+	node := &recentNode{
+		trackerNode:   *newTrackerNode(span),
+		Offset:        span.StartTime.Sub(rootStart),
+		DroppedEvents: span.DroppedEvents,
+		DroppedLinks:  span.DroppedLinks,
+	}
+
+	for _, e := range span.Events {
+		node.Events = append(node.Events, recentEvent{
+			Offset:     e.Time.Sub(rootStart),
+			Name:       e.Name,
+			Attributes: formatAttributes(e.Attributes),
+		})
+	}
+
+	for _, child := range span.Children {
+		node.Children = append(node.Children, newRecentNode(child, rootStart))
+	}
+
+	return node
+}
+
+var recentTemplate = template.Must(template.New("recent").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Recent telemetries</title></head>
+<body style="font-family: monospace">
+{{define "node"}}<li>+{{.Offset}} #{{.ID}} <b>{{.Name}}</b> took {{.Elapsed}}{{if .Error}} <span style="color: red">error: {{.Error}}</span>{{end}}{{range .Data}} [{{.}}]{{end}}
+{{if .Links}}<ul>{{range .Links}}<li>&rarr; link {{.}}</li>{{end}}{{if .DroppedLinks}}<li>({{.DroppedLinks}} links dropped)</li>{{end}}</ul>{{end}}
+{{if .Events}}<ul>{{range .Events}}<li>+{{.Offset}} <i>{{.Name}}</i>{{range .Attributes}} [{{.}}]{{end}}</li>{{end}}{{if .DroppedEvents}}<li>({{.DroppedEvents}} events dropped)</li>{{end}}</ul>{{end}}
+{{if .Children}}<ul>{{range .Children}}{{template "node" .}}{{end}}</ul>{{end}}</li>
+{{end}}<table border="1" cellpadding="4" style="border-collapse: collapse">
+<tr><th>name</th>{{range $.Buckets}}<th>&ge;{{.}}</th>{{end}}<th>errors</th></tr>
+{{range .Summaries}}{{$name := .Name}}<tr><td>{{.Name}}</td>{{range $i, $n := .Buckets}}<td><a href="?name={{$name}}&amp;bucket={{$i}}">{{$n}}</a></td>{{end}}<td><a href="?name={{.Name}}&amp;errors=1">{{.Errors}}</a></td></tr>
+{{end}}</table>
+{{if .Traces}}<h3>{{.Title}}</h3>
+<table border="1" cellpadding="4" style="border-collapse: collapse">
+<tr><th>started</th><th>elapsed</th><th>id</th><th>error</th></tr>
+{{range .Traces}}<tr><td>{{.Started.Format "2006-01-02 15:04:05.000000"}}</td><td>{{.Elapsed}}</td><td><a href="?id={{.ID}}">#{{.ID}}</a></td><td>{{.Error}}</td></tr>
+{{end}}</table>{{end}}
+{{with .Tree}}<h3>#{{.ID}} {{.Name}}</h3><ul>{{template "node" .}}</ul>{{end}}
+</body>
+</html>
+`))
+
+func (self *RecentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	page := struct {
+		Buckets   []time.Duration
+		Summaries []recentSummary
+		Title     string
+		Traces    []*trackerNode
+		Tree      *recentNode
+	}{
+		Buckets: RecentBuckets,
+	}
+
+	self.mu.Lock()
+
+	for name, family := range self.families {
+		summary := recentSummary{
+			Name:   name,
+			Errors: len(family.errors.items),
+		}
+
+		for _, b := range family.buckets {
+			summary.Buckets = append(summary.Buckets, len(b.items))
+		}
+
+		page.Summaries = append(page.Summaries, summary)
+	}
+
+	self.mu.Unlock()
+
+	sort.Slice(page.Summaries, func(i, j int) bool {
+		return page.Summaries[i].Name < page.Summaries[j].Name
+	})
+
+	var traces []*SpanData
+
+	if name := r.FormValue("name"); len(name) > 0 {
+		if len(r.FormValue("errors")) > 0 {
+			page.Title = fmt.Sprintf("%s: errors", name)
+			traces = self.RecentErrors(name)
+		} else if bucket, err := strconv.Atoi(r.FormValue("bucket")); err == nil && bucket >= 0 && bucket < len(RecentBuckets) {
+			page.Title = fmt.Sprintf("%s: ≥%v", name, RecentBuckets[bucket])
+			traces = self.Recent(name, bucket)
+		}
+	}
+
+	for _, span := range traces {
+		page.Traces = append(page.Traces, newTrackerNode(span))
+	}
+
+	if id, err := strconv.ParseUint(r.FormValue("id"), 10, 64); err == nil {
+		if span := self.find(id); span != nil {
+			page.Tree = newRecentNode(span, span.StartTime)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	recentTemplate.Execute(w, page)
+}
@@ -0,0 +1,76 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func closeAfter(handler *RecentHandler, name string, elapsed time.Duration, err error) *SpanData {
+
+	t := NewTelemetry(context.Background(), name, handler)
+	t.SetError(err)
+
+	// pretend the telemetry took elapsed
+	t.End(WithEndTime(t.StartTime().Add(elapsed)))
+
+	return t.Snapshot()
+}
+
+func TestRecentHandlerBucketsByLatency(t *testing.T) {
+
+	handler := NewRecentHandler(2)
+
+	fast := closeAfter(handler, "test.recent", time.Millisecond, nil)
+	slow := closeAfter(handler, "test.recent", 150*time.Millisecond, nil)
+	failed := closeAfter(handler, "test.recent", 20*time.Millisecond, errors.New("boom"))
+
+	if recent := handler.Recent("test.recent", 0); len(recent) != 2 || recent[0] != failed || recent[1] != slow {
+		t.Errorf("Bucket 0 should keep the last 2 telemetries, got %v.", recent)
+	}
+
+	if recent := handler.Recent("test.recent", 2); len(recent) != 1 || recent[0] != slow {
+		t.Errorf("Bucket 2 should only keep slow, got %v.", recent)
+	}
+
+	if recent := handler.RecentErrors("test.recent"); len(recent) != 1 || recent[0] != failed {
+		t.Errorf("Errors should only keep failed, got %v.", recent)
+	}
+
+	if handler.find(fast.ID) != nil {
+		t.Error("fast should have been evicted.")
+	}
+}
+
+func TestRecentHandlerServesTree(t *testing.T) {
+
+	handler := NewRecentHandler(0)
+
+	root := NewTelemetry(context.Background(), "test.recent.page", handler)
+	nested := NewTelemetry(root, "test.recent.nested")
+	nested.AddEvent("cache miss", "key", "user:1")
+	nested.RecordValue("rows", 3)
+	root.Close()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/telemetry/recent?name=test.recent.page&bucket=0", nil))
+
+	if body := recorder.Body.String(); !strings.Contains(body, fmt.Sprintf(`href="?id=%d"`, root.ID())) {
+		t.Errorf("Bucket listing does not link to the tree:\n%s", body)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", fmt.Sprintf("/debug/telemetry/recent?id=%d", root.ID()), nil))
+
+	body := recorder.Body.String()
+
+	for _, expected := range []string{"test.recent.nested", "<i>cache miss</i> [key=user:1]", "[rows=3]"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Tree page does not contain %q:\n%s", expected, body)
+		}
+	}
+}
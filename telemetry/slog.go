@@ -0,0 +1,222 @@
+package telemetry
+
+import (
+	"fmt"
+	"github.com/israelchen/gomon/util"
+	"golang.org/x/net/context"
+	"log/slog"
+)
+
+// LogHandler is a slog.Handler wrapping another one. Records logged with a context carrying a
+// telemetry are annotated with the telemetry's name, trace and span IDs and selected recorded
+// values, and can also be added to the telemetry as events.
+//
+// The annotations are top-level attributes even when the logger has groups. Events include the
+// attributes bound with Logger.With, with the keys of grouped attributes qualified by their
+// groups, e.g. "request.method".
+type LogHandler struct {
+	next   slog.Handler
+	bound  slog.Handler
+	scopes []scope
+	keys   []interface{}
+	events bool
+}
+
+// scope is a group opened by WithGroup, or attributes bound by WithAttrs.
+type scope struct {
+	group string
+	attrs []slog.Attr
+}
+
+type LogHandlerOption func(handler *LogHandler)
+
+// WithLogValues adds the values recorded under keys by RecordValue to log records. Values are
+// looked up with Value, so they may also come from a parent telemetry or context.
+func WithLogValues(keys ...interface{}) LogHandlerOption {
+	return func(handler *LogHandler) {
+		handler.keys = append(handler.keys, keys...)
+	}
+}
+
+// WithLogEvents also adds log records to the telemetry as events named after the message.
+func WithLogEvents() LogHandlerOption {
+	return func(handler *LogHandler) {
+		handler.events = true
+	}
+}
+
+func NewLogHandler(next slog.Handler, options ...LogHandlerOption) *LogHandler {
+	util.Require(next != nil, "telemetry: next cannot be nil.")
+
+	handler := &LogHandler{
+		next:  next,
+		bound: next,
+	}
+
+	for _, option := range options {
+		option(handler)
+	}
+
+	return handler
+}
+
+func (self *LogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return self.bound.Enabled(ctx, level)
+}
+
+func (self *LogHandler) Handle(ctx context.Context, record slog.Record) error {
+
+	if ctx == nil {
+		return self.bound.Handle(ctx, record)
+	}
+
+	t, ok := FromContext(ctx)
+
+	if !ok {
+		return self.bound.Handle(ctx, record)
+	}
+
+	if self.events {
+		keyvals := []interface{}{"level", record.Level.String()}
+		prefix := ""
+
+		for _, scope := range self.scopes {
+			if len(scope.group) > 0 {
+				prefix += scope.group + "."
+			}
+
+			for _, attr := range scope.attrs {
+				keyvals = appendAttr(keyvals, prefix, attr)
+			}
+		}
+
+		record.Attrs(func(attr slog.Attr) bool {
+			keyvals = appendAttr(keyvals, prefix, attr)
+			return true
+		})
+
+		t.AddEvent(record.Message, keyvals...)
+	}
+
+	attrs := telemetryAttrs(t.Name(), t.TraceID(), t.SpanID())
+
+	for _, key := range self.keys {
+		if value := t.Value(key); value != nil {
+			attrs = append(attrs, slog.Any(fmt.Sprint(key), value))
+		}
+	}
+
+	// the annotations are bound before the groups and attributes of the logger are replayed, which
+	// keeps them at the top level
+	handler := self.next.WithAttrs(attrs)
+
+	for _, scope := range self.scopes {
+		if len(scope.group) > 0 {
+			handler = handler.WithGroup(scope.group)
+		} else {
+			handler = handler.WithAttrs(scope.attrs)
+		}
+	}
+
+	return handler.Handle(ctx, record)
+}
+
+func (self *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	if len(attrs) == 0 {
+		return self
+	}
+
+	return self.with(scope{attrs: attrs}, self.bound.WithAttrs(attrs))
+}
+
+func (self *LogHandler) WithGroup(name string) slog.Handler {
+
+	if len(name) == 0 {
+		return self
+	}
+
+	return self.with(scope{group: name}, self.bound.WithGroup(name))
+}
+
+func (self *LogHandler) with(s scope, bound slog.Handler) *LogHandler {
+	return &LogHandler{
+		next:   self.next,
+		bound:  bound,
+		scopes: append(self.scopes[:len(self.scopes):len(self.scopes)], s),
+		keys:   self.keys,
+		events: self.events,
+	}
+}
+
+// appendAttr adds an attribute to event keyvals, flattening groups into qualified keys.
+func appendAttr(keyvals []interface{}, prefix string, attr slog.Attr) []interface{} {
+
+	value := attr.Value.Resolve()
+
+	if value.Kind() != slog.KindGroup {
+		return append(keyvals, prefix+attr.Key, value.Any())
+	}
+
+	if len(attr.Key) > 0 {
+		prefix += attr.Key + "."
+	}
+
+	for _, member := range value.Group() {
+		keyvals = appendAttr(keyvals, prefix, member)
+	}
+
+	return keyvals
+}
+
+// SlogHandler is a telemetry handler logging the start and end of telemetries through slog.
+// Starts are logged at debug level, ends at info level, or error level when the telemetry failed.
+type SlogHandler struct {
+	logger *slog.Logger
+}
+
+func NewSlogHandler(logger *slog.Logger) *SlogHandler {
+	util.Require(logger != nil, "telemetry: logger cannot be nil.")
+
+	return &SlogHandler{
+		logger: logger,
+	}
+}
+
+func (self *SlogHandler) Started(t *Telemetry) {
+	util.Require(t != nil, "telemetry: telemetry cannot be nil.")
+
+	self.logger.LogAttrs(context.Background(), slog.LevelDebug, "telemetry started", telemetryAttrs(t.Name(), t.TraceID(), t.SpanID())...)
+}
+
+func (self *SlogHandler) Ended(span *SpanData) {
+	util.Require(span != nil, "telemetry: span cannot be nil.")
+
+	attrs := append(telemetryAttrs(span.Name, span.TraceID, span.SpanID), slog.Duration("elapsed", span.Elapsed()))
+	level := slog.LevelInfo
+
+	if len(span.Links) > 0 {
+		links := make([]string, 0, len(span.Links))
+
+		for _, link := range span.Links {
+			links = append(links, link.String())
+		}
+
+		attrs = append(attrs, slog.Any("links", links))
+	}
+
+	if span.Err != nil {
+		attrs = append(attrs, slog.String("error", span.Err.Error()))
+		level = slog.LevelError
+	}
+
+	self.logger.LogAttrs(context.Background(), level, "telemetry ended", attrs...)
+}
+
+func telemetryAttrs(name string, traceID TraceID, spanID SpanID) []slog.Attr {
+	return []slog.Attr{
+		slog.String("telemetry", name),
+		slog.String("traceId", traceID.String()),
+		slog.String("spanId", spanID.String()),
+	}
+}
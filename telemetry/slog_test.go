@@ -0,0 +1,125 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/context"
+	"log/slog"
+	"testing"
+)
+
+func TestLogHandlerAnnotatesRecords(t *testing.T) {
+
+	var b bytes.Buffer
+
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&b, nil), WithLogValues("user"), WithLogEvents()))
+
+	root := NewTelemetry(context.Background(), "test.log.root")
+	root.RecordValue("user", "alice")
+	nested := NewTelemetry(root, "test.log.nested")
+
+	logger.InfoContext(nested, "cache miss", "key", "user:1")
+
+	var record map[string]interface{}
+
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"msg":       "cache miss",
+		"key":       "user:1",
+		"telemetry": "test.log.nested",
+		"traceId":   root.TraceID().String(),
+		"spanId":    nested.SpanID().String(),
+		"user":      "alice",
+	}
+
+	for k, v := range expected {
+		if record[k] != v {
+			t.Errorf("Expected %s to be %v, got %v.", k, v, record[k])
+		}
+	}
+
+	events := nested.Events()
+
+	if len(events) != 1 || events[0].Name != "cache miss" || events[0].Attributes["key"] != "user:1" || events[0].Attributes["level"] != "INFO" {
+		t.Errorf("Log record was not added as an event: %+v.", events)
+	}
+
+	b.Reset()
+	logger.Info("no telemetry")
+
+	if bytes.Contains(b.Bytes(), []byte("traceId")) {
+		t.Errorf("Record without telemetry was annotated: %s", b.String())
+	}
+}
+
+func TestLogHandlerGroupsAndAttrs(t *testing.T) {
+
+	var b bytes.Buffer
+
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&b, nil), WithLogEvents())).With("tenant", "t1").WithGroup("request").With("method", "GET")
+
+	tel := NewTelemetry(context.Background(), "test.log.groups")
+	logger.InfoContext(tel, "handled", "status", 200)
+
+	var record map[string]interface{}
+
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	group, _ := record["request"].(map[string]interface{})
+
+	if record["traceId"] != tel.TraceID().String() || record["tenant"] != "t1" || group["method"] != "GET" || group["status"] != float64(200) {
+		t.Errorf("Record is different than expected: %s", b.String())
+	}
+
+	if _, ok := group["traceId"]; ok {
+		t.Errorf("Telemetry attributes were nested under the group: %s", b.String())
+	}
+
+	events := tel.Events()
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %+v.", events)
+	}
+
+	attributes := events[0].Attributes
+
+	if attributes["tenant"] != "t1" || attributes["request.method"] != "GET" || attributes["request.status"] != int64(200) {
+		t.Errorf("Event attributes are different than expected: %v.", attributes)
+	}
+}
+
+func TestSlogHandlerLogsStartAndEnd(t *testing.T) {
+
+	var b bytes.Buffer
+
+	handler := NewSlogHandler(slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	ctx := NewTelemetry(context.Background(), "test.slog", handler)
+	ctx.SetError(errors.New("boom"))
+	ctx.Close()
+
+	lines := bytes.Split(bytes.TrimSpace(b.Bytes()), []byte("\n"))
+
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records, got %d.", len(lines))
+	}
+
+	var started, ended map[string]interface{}
+
+	json.Unmarshal(lines[0], &started)
+	json.Unmarshal(lines[1], &ended)
+
+	if started["level"] != "DEBUG" || started["msg"] != "telemetry started" || started["telemetry"] != "test.slog" {
+		t.Errorf("Start record is different than expected: %s", lines[0])
+	}
+
+	if ended["level"] != "ERROR" || ended["error"] != "boom" || ended["spanId"] != ctx.SpanID().String() {
+		t.Errorf("End record is different than expected: %s", lines[1])
+	}
+}
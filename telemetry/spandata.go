@@ -0,0 +1,134 @@
+package telemetry
+
+import (
+	"time"
+)
+
+// SpanData is an immutable snapshot of a telemetry, built once when it is closed and handed to
+// every handler's Ended. Since it does not refer to the live telemetry, handlers may keep it, queue
+// it or export it asynchronously regardless of what happens to the telemetry afterwards.
+//
+// Snapshots are shared between handlers, and between a parent and its children's snapshots, so
+// they must not be modified.
+type SpanData struct {
+	ID       uint64
+	TraceID  TraceID
+	SpanID   SpanID
+	ParentID uint64 // zero for roots
+	Name     string
+
+	// Path is the names from the root down to the telemetry, separated by slashes.
+	Path string
+
+	StartTime time.Time
+
+	// EndTime is when the telemetry was closed or, for snapshots of telemetries that were still
+	// open, when the snapshot was taken.
+	EndTime time.Time
+	Ended   bool
+
+	Err    error
+	Result interface{}
+
+	// Data holds the values recorded by RecordValue.
+	Data map[interface{}]interface{}
+
+	// Baggage holds the baggage seen by the telemetry, including the one set on its ancestors.
+	Baggage map[string]string
+
+	Events []Event
+	Links  []Link
+
+	// Children are the snapshots of the children attached when the snapshot was taken.
+	Children []*SpanData
+
+	DroppedChildren int64
+	DroppedData     int64
+	DroppedEvents   int64
+	DroppedLinks    int64
+}
+
+func (self *SpanData) Elapsed() time.Duration {
+	return self.EndTime.Sub(self.StartTime)
+}
+
+// Snapshot returns the SpanData of the telemetry: the one handed to handlers once it is closed or,
+// while it is still open, a snapshot of its current state.
+func (self *Telemetry) Snapshot() *SpanData {
+
+	self.mu.RLock()
+	span := self.spanData
+	self.mu.RUnlock()
+
+	if span != nil {
+		return span
+	}
+
+	return self.snapshot(self.clock.Now())
+}
+
+// snapshot builds the SpanData of the telemetry, reusing the snapshots of the children that were
+// closed. now stands for the end time of the telemetries that are still open.
+func (self *Telemetry) snapshot(now time.Time) *SpanData {
+
+	span := &SpanData{
+		ID:        self.id,
+		TraceID:   self.traceID,
+		SpanID:    self.spanID,
+		Name:      self.name,
+		Path:      self.name,
+		StartTime: *self.startTime,
+		EndTime:   now,
+	}
+
+	for parent := self.parent; parent != nil; parent = parent.parent {
+		if span.ParentID == 0 {
+			span.ParentID = parent.id
+		}
+
+		span.Path = parent.name + "/" + span.Path
+	}
+
+	// the baggage is read before locking as it locks the ancestors
+	span.Baggage = self.Baggage()
+
+	self.mu.RLock()
+
+	if self.endTime != nil {
+		span.EndTime = *self.endTime
+		span.Ended = true
+	}
+
+	span.Err = self.err
+	span.Result = self.result
+	span.Data = make(map[interface{}]interface{}, len(self.data))
+
+	for k, v := range self.data {
+		span.Data[k] = v
+	}
+
+	span.Events = append([]Event(nil), self.events...)
+	span.Links = append([]Link(nil), self.links...)
+	span.DroppedChildren = self.droppedChildren
+	span.DroppedData = self.droppedData
+	span.DroppedEvents = self.droppedEvents
+	span.DroppedLinks = self.droppedLinks
+
+	children := append([]*Telemetry(nil), self.children...)
+
+	self.mu.RUnlock()
+
+	for _, child := range children {
+		child.mu.RLock()
+		childSpan := child.spanData
+		child.mu.RUnlock()
+
+		if childSpan == nil {
+			childSpan = child.snapshot(now)
+		}
+
+		span.Children = append(span.Children, childSpan)
+	}
+
+	return span
+}
@@ -0,0 +1,190 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"github.com/israelchen/gomon/perfcounters"
+	"github.com/israelchen/gomon/util"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StatsHandler aggregates closed telemetry trees by name path (e.g. "foo/db.query/dial") so that
+// the operations contributing most to a parent's latency can be found across many requests.
+// It is an http.Handler serving the aggregated statistics as an HTML table, or as JSON when the
+// request has a format=json query parameter.
+type StatsHandler struct {
+	paths map[string]*PathStats
+	mu    sync.RWMutex
+}
+
+// PathStats holds the aggregated statistics of all telemetries sharing a name path. Latency
+// histograms are in milliseconds.
+type PathStats struct {
+	Path     string
+	calls    *perfcounters.NumberOfItems64
+	errors   *perfcounters.NumberOfItems64
+	total    *perfcounters.Histogram
+	selfTime *perfcounters.Histogram
+}
+
+func NewStatsHandler() *StatsHandler {
+	return &StatsHandler{
+		paths: make(map[string]*PathStats),
+	}
+}
+
+func (self *StatsHandler) Started(t *Telemetry) {
+	util.Require(t != nil, "telemetry: telemetry cannot be nil.")
+}
+
+func (self *StatsHandler) Ended(span *SpanData) {
+	util.Require(span != nil, "telemetry: span cannot be nil.")
+
+	walkTree(AnalyzeTree(span, 0).Root, func(node *TreeNode, depth int) {
+		stats := self.path(node.Path)
+
+		stats.calls.Increment()
+
+		if len(node.Error) > 0 {
+			stats.errors.Increment()
+		}
+
+		stats.total.Add(toMilliseconds(node.Duration))
+		stats.selfTime.Add(toMilliseconds(node.SelfTime))
+	})
+}
+
+func (self *StatsHandler) path(path string) *PathStats {
+
+	self.mu.RLock()
+	stats, ok := self.paths[path]
+	self.mu.RUnlock()
+
+	if ok {
+		return stats
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if stats, ok := self.paths[path]; ok {
+		return stats
+	}
+
+	stats = &PathStats{
+		Path:     path,
+		calls:    perfcounters.NewNumberOfItems64(),
+		errors:   perfcounters.NewNumberOfItems64(),
+		total:    perfcounters.NewHistogram(perfcounters.LatencyBuckets...),
+		selfTime: perfcounters.NewHistogram(perfcounters.LatencyBuckets...),
+	}
+
+	self.paths[path] = stats
+
+	return stats
+}
+
+// Stats returns the statistics of every path seen so far, ordered by the total self time spent in
+// them, i.e. by how much they contributed to latency overall.
+func (self *StatsHandler) Stats() []*PathStats {
+
+	self.mu.RLock()
+
+	stats := make([]*PathStats, 0, len(self.paths))
+
+	for _, s := range self.paths {
+		stats = append(stats, s)
+	}
+
+	self.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].selfTime.Sum() > stats[j].selfTime.Sum()
+	})
+
+	return stats
+}
+
+func (self *PathStats) Calls() *perfcounters.NumberOfItems64 {
+	return self.calls
+}
+
+func (self *PathStats) Errors() *perfcounters.NumberOfItems64 {
+	return self.errors
+}
+
+func (self *PathStats) Total() *perfcounters.Histogram {
+	return self.total
+}
+
+func (self *PathStats) SelfTime() *perfcounters.Histogram {
+	return self.selfTime
+}
+
+type latencyStats struct {
+	Sum  float64 `json:"sum"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+type pathStatsRow struct {
+	Path     string       `json:"path"`
+	Calls    int64        `json:"calls"`
+	Errors   int64        `json:"errors"`
+	Total    latencyStats `json:"totalMs"`
+	SelfTime latencyStats `json:"selfTimeMs"`
+}
+
+func newLatencyStats(h *perfcounters.Histogram) latencyStats {
+	return latencyStats{h.Sum(), h.Mean(), h.Quantile(0.5), h.Quantile(0.9), h.Quantile(0.99), h.Quantile(1)}
+}
+
+var statsTemplate = template.Must(template.New("stats").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Telemetry statistics</title></head>
+<body>
+<table border="1" cellpadding="4" style="border-collapse: collapse; font-family: monospace">
+<tr><th rowspan="2">path</th><th rowspan="2">calls</th><th rowspan="2">errors</th><th colspan="5">total (ms)</th><th colspan="5">self time (ms)</th></tr>
+<tr><th>mean</th><th>p50</th><th>p90</th><th>p99</th><th>max</th><th>sum</th><th>mean</th><th>p50</th><th>p90</th><th>p99</th></tr>
+{{range .}}<tr><td>{{.Path}}</td><td>{{.Calls}}</td><td>{{.Errors}}</td>
+<td>{{printf "%.2f" .Total.Mean}}</td><td>{{printf "%.2f" .Total.P50}}</td><td>{{printf "%.2f" .Total.P90}}</td><td>{{printf "%.2f" .Total.P99}}</td><td>{{printf "%.2f" .Total.Max}}</td>
+<td>{{printf "%.2f" .SelfTime.Sum}}</td><td>{{printf "%.2f" .SelfTime.Mean}}</td><td>{{printf "%.2f" .SelfTime.P50}}</td><td>{{printf "%.2f" .SelfTime.P90}}</td><td>{{printf "%.2f" .SelfTime.P99}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (self *StatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	stats := self.Stats()
+	rows := make([]pathStatsRow, 0, len(stats))
+
+	for _, s := range stats {
+		rows = append(rows, pathStatsRow{
+			Path:     s.Path,
+			Calls:    s.calls.Value(),
+			Errors:   s.errors.Value(),
+			Total:    newLatencyStats(s.total),
+			SelfTime: newLatencyStats(s.selfTime),
+		})
+	}
+
+	if r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(rows)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	statsTemplate.Execute(w, rows)
+}
+
+func toMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,69 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatsHandlerAggregatesByPath(t *testing.T) {
+
+	handler := NewStatsHandler()
+
+	for i := 0; i < 3; i++ {
+		root := newTestTree()
+
+		if i == 0 {
+			root.Children()[1].SetError(errors.New("boom"))
+		}
+
+		handler.Ended(root.Snapshot())
+	}
+
+	stats := handler.Stats()
+
+	if len(stats) != 6 {
+		t.Fatalf("Expected 6 paths, got %d.", len(stats))
+	}
+
+	// query spends the most time on its own, 40ms per call.
+	if stats[0].Path != "root/query" || stats[0].SelfTime().Sum() != 120 {
+		t.Errorf("Expected root/query to contribute most, got %s with %vms.", stats[0].Path, stats[0].SelfTime().Sum())
+	}
+
+	if stats[0].Calls().Value() != 3 || stats[0].Errors().Value() != 1 {
+		t.Errorf("Expected 3 calls and 1 error, got %d and %d.", stats[0].Calls().Value(), stats[0].Errors().Value())
+	}
+
+	if stats[0].Total().Mean() != 60 {
+		t.Errorf("Expected mean total of 60ms, got %v.", stats[0].Total().Mean())
+	}
+}
+
+func TestStatsHandlerServesJSONAndHTML(t *testing.T) {
+
+	handler := NewStatsHandler()
+	handler.Ended(newTestTree().Snapshot())
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/telemetry/stats?format=json", nil))
+
+	var rows []pathStatsRow
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 6 || rows[0].Path != "root/query" || rows[0].SelfTime.Sum != 40 {
+		t.Errorf("JSON rows are different than expected: %+v.", rows)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/telemetry/stats", nil))
+
+	if !strings.Contains(recorder.Body.String(), "<td>root/query/dial</td>") {
+		t.Error("HTML table does not list root/query/dial.")
+	}
+}
@@ -1,56 +1,121 @@
 package telemetry
 
 import (
+	"fmt"
+	"github.com/israelchen/gomon/clock"
 	"github.com/israelchen/gomon/util"
 	"golang.org/x/net/context"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Handler is notified when telemetries start and end. Started is given the live telemetry, while
+// Ended is given its immutable snapshot, which is safe to keep and to process asynchronously.
 type Handler interface {
 	Started(telemetry *Telemetry)
-	Ended(telemetry *Telemetry)
+	Ended(span *SpanData)
+}
+
+// Event is something that happened at a point in time during a telemetry.
+type Event struct {
+	Time       time.Time
+	Name       string
+	Attributes map[string]interface{}
+}
+
+// Link relates a telemetry to another one outside of its tree, such as a batch to each of the
+// requests whose messages it processes.
+type Link struct {
+	SpanContext
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
 }
 
 type Telemetry struct {
 	context.Context
+	id        uint64
+	traceID   TraceID
+	spanID    SpanID
 	name      string
 	startTime *time.Time
 	endTime   *time.Time
 	err       error
 	result    interface{}
 	data      map[interface{}]interface{}
+	events    []Event
+	links     []Link
+	baggage   map[string]string
 	mu        sync.RWMutex
+	parent    *Telemetry
 	children  []*Telemetry
 	handlers  []Handler
-	closed    bool
+	state     int32
+	limits    Limits
+	clock     clock.Clock
+	spanData  *SpanData
+
+	droppedChildren int64
+	droppedData     int64
+	droppedEvents   int64
+	droppedLinks    int64
+	lateWrites      int64
 }
 
+// A telemetry is open until End or Close is called, ending while its children are closed and its
+// snapshot is built, then ended. Only the first call ends it, and writes made once it is no longer
+// open are ignored and counted by LateWrites, so that the snapshot handlers are given is final.
+const (
+	stateOpen int32 = iota
+	stateEnding
+	stateEnded
+)
+
 var telemetryKey int = 0
 
+var lastID uint64
+
 func NewTelemetry(parent context.Context, name string, handlers ...Handler) (t *Telemetry) {
+	return NewTelemetryWithOptions(parent, name, WithHandlers(handlers...))
+}
+
+func NewTelemetryWithOptions(parent context.Context, name string, options ...Option) (t *Telemetry) {
 
 	util.Require(parent != nil, "telemetry: parent cannot be nil.")
 	util.Require(len(name) > 0, "telemetry: name cannot be empty.")
 
-	startTime := time.Now()
-
 	t = &Telemetry{
-		Context:   parent,
-		name:      name,
-		startTime: &startTime,
-		endTime:   nil,
-		handlers:  handlers,
-		data:      make(map[interface{}]interface{}),
-		children:  nil,
+		Context:  parent,
+		id:       atomic.AddUint64(&lastID, 1),
+		spanID:   newSpanID(),
+		name:     name,
+		endTime:  nil,
+		data:     make(map[interface{}]interface{}),
+		children: nil,
+		clock:    clock.Real,
 	}
 
 	// search up the context chain for a parent telemetry
 	parentTelemetry := parent.Value(telemetryKey)
 
 	if parentTelemetry != nil {
+		t.parent = parentTelemetry.(*Telemetry)
+		t.traceID = t.parent.traceID
+		t.limits = t.parent.limits
+		t.clock = t.parent.clock
+	} else {
+		t.traceID = newTraceID()
+	}
+
+	for _, option := range options {
+		option(t)
+	}
+
+	startTime := t.clock.Now()
+	t.startTime = &startTime
+
+	if t.parent != nil {
 		// attach ourselves to parent telemetry
-		parentTelemetry.(*Telemetry).attach(t)
+		t.parent.attach(t)
 	}
 
 	for _, handler := range t.handlers {
@@ -74,40 +139,236 @@ func (self *Telemetry) attach(child *Telemetry) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
+	if !self.writable() {
+		return
+	}
+
+	if self.limits.MaxChildren > 0 && len(self.children) >= self.limits.MaxChildren {
+		self.droppedChildren += 1
+		return
+	}
+
 	// attach child to telemetry
 	self.children = append(self.children, child)
 }
 
-func (self *Telemetry) Close() {
-
-	endTime := time.Now()
-	self.endTime = &endTime
+func (self *Telemetry) detach(child *Telemetry) {
 
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
-	if self.closed {
+	// children of a closed telemetry are part of what its handlers were given, leave them be
+	if atomic.LoadInt32(&self.state) != stateOpen {
 		return
 	}
 
-	for _, child := range self.children {
-		child.Close()
+	for i, c := range self.children {
+		if c == child {
+			self.children = append(self.children[:i], self.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// Close ends the telemetry now. It is the same as End without options.
+func (self *Telemetry) Close() {
+	self.End()
+}
+
+// EndOption configures how End ends a telemetry.
+type EndOption func(options *endOptions)
+
+type endOptions struct {
+	endTime *time.Time
+}
+
+// WithEndTime ends the telemetry at the given time rather than now, e.g. when the operation it
+// measures finished earlier than it is reported. The end time cannot be before the start time.
+func WithEndTime(endTime time.Time) EndOption {
+	return func(options *endOptions) {
+		options.endTime = &endTime
+	}
+}
+
+// End ends the telemetry and the children that are still open, which end at the same time, and
+// then invokes the handlers. It is safe to call concurrently; only the first call ends the
+// telemetry and later ones do nothing.
+func (self *Telemetry) End(options ...EndOption) {
+
+	var o endOptions
+
+	for _, option := range options {
+		option(&o)
+	}
+
+	endTime := self.clock.Now()
+
+	if o.endTime != nil {
+		util.Require(!o.endTime.Before(*self.startTime), "telemetry: endTime cannot be before the start time.")
+		endTime = *o.endTime
+	}
+
+	if self.close(endTime) && self.parent != nil && self.parent.limits.DetachClosedChildren {
+		self.parent.detach(self)
+	}
+}
+
+// close ends the telemetry and its children, returning false if it was already ended.
+func (self *Telemetry) close(endTime time.Time) bool {
+
+	if !atomic.CompareAndSwapInt32(&self.state, stateOpen, stateEnding) {
+		return false
+	}
+
+	// children started after the explicit end time of their parent end when they started
+	if endTime.Before(*self.startTime) {
+		endTime = *self.startTime
+	}
+
+	self.mu.Lock()
+	self.endTime = &endTime
+	children := append([]*Telemetry(nil), self.children...)
+
+	// handlers are invoked without holding the lock so they can read the telemetry and its tree
+	self.mu.Unlock()
+
+	for _, child := range children {
+		child.close(endTime)
 	}
 
+	// children are closed first so that their snapshots are reused by ours
+	span := self.snapshot(endTime)
+
+	self.mu.Lock()
+	self.spanData = span
+	self.mu.Unlock()
+
+	atomic.StoreInt32(&self.state, stateEnded)
+
 	for _, handler := range self.handlers {
-		handler.Ended(self)
+		handler.Ended(span)
+	}
+
+	return true
+}
+
+// writable returns whether the telemetry is still open, counting a late write otherwise. It must be
+// called with the lock held.
+func (self *Telemetry) writable() bool {
+
+	if atomic.LoadInt32(&self.state) == stateOpen {
+		return true
 	}
 
-	self.closed = true
+	self.lateWrites += 1
+	return false
+}
+
+// Ended returns whether End or Close was called.
+func (self *Telemetry) Ended() bool {
+	return atomic.LoadInt32(&self.state) != stateOpen
 }
 
 func (self *Telemetry) RecordValue(key interface{}, value interface{}) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
+	if !self.writable() {
+		return
+	}
+
+	if _, ok := self.data[key]; !ok && self.limits.MaxData > 0 && len(self.data) >= self.limits.MaxData {
+		self.droppedData += 1
+		return
+	}
+
 	self.data[key] = value
 }
 
+// AddEvent records a named event with attributes given as alternating keys and values.
+func (self *Telemetry) AddEvent(name string, keyvals ...interface{}) {
+	util.Require(len(name) > 0, "telemetry: name cannot be empty.")
+
+	event := Event{
+		Time:       self.clock.Now(),
+		Name:       name,
+		Attributes: newAttributes(keyvals),
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if !self.writable() {
+		return
+	}
+
+	if self.limits.MaxEvents > 0 && len(self.events) >= self.limits.MaxEvents {
+		self.droppedEvents += 1
+		return
+	}
+
+	self.events = append(self.events, event)
+}
+
+// Events returns a copy of the events recorded by AddEvent.
+func (self *Telemetry) Events() []Event {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return append([]Event(nil), self.events...)
+}
+
+// AddLink relates the telemetry to another one, typically from another trace, with attributes
+// given as alternating keys and values. Unlike the parent, links do not affect the tree.
+func (self *Telemetry) AddLink(sc SpanContext, keyvals ...interface{}) {
+	util.Require(sc.IsValid(), "telemetry: sc is not valid.")
+
+	link := Link{
+		SpanContext: sc,
+		Attributes:  newAttributes(keyvals),
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if !self.writable() {
+		return
+	}
+
+	if self.limits.MaxLinks > 0 && len(self.links) >= self.limits.MaxLinks {
+		self.droppedLinks += 1
+		return
+	}
+
+	self.links = append(self.links, link)
+}
+
+// Links returns a copy of the links added by AddLink.
+func (self *Telemetry) Links() []Link {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return append([]Link(nil), self.links...)
+}
+
+// newAttributes turns alternating keys and values into attributes, keeping a trailing key without
+// a value under "!BADKEY" as slog does.
+func newAttributes(keyvals []interface{}) map[string]interface{} {
+
+	attributes := make(map[string]interface{}, len(keyvals)/2)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 == len(keyvals) {
+			attributes["!BADKEY"] = keyvals[i]
+			break
+		}
+
+		attributes[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
+
+	return attributes
+}
+
 func (self *Telemetry) Value(key interface{}) interface{} {
 
 	if key == telemetryKey {
@@ -130,7 +391,7 @@ func (self *Telemetry) Keys() []interface{} {
 	self.mu.RLock()
 	defer self.mu.RUnlock()
 
-	keys := make([]interface{}, len(self.data))
+	keys := make([]interface{}, 0, len(self.data))
 
 	for k := range self.data {
 		keys = append(keys, k)
@@ -139,34 +400,144 @@ func (self *Telemetry) Keys() []interface{} {
 	return keys
 }
 
+// Data returns a copy of the values recorded by RecordValue.
+func (self *Telemetry) Data() map[interface{}]interface{} {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	data := make(map[interface{}]interface{}, len(self.data))
+
+	for k, v := range self.data {
+		data[k] = v
+	}
+
+	return data
+}
+
+// ID returns an identifier unique to the telemetry within the process.
+func (self *Telemetry) ID() uint64 {
+	return self.id
+}
+
+func (self *Telemetry) TraceID() TraceID {
+	return self.traceID
+}
+
+func (self *Telemetry) SpanID() SpanID {
+	return self.spanID
+}
+
+// SpanContext returns the trace and span IDs of the telemetry, e.g. for other telemetries to link
+// to it.
+func (self *Telemetry) SpanContext() SpanContext {
+	return SpanContext{self.traceID, self.spanID}
+}
+
 func (self *Telemetry) Name() string {
 	return self.name
 }
 
 func (self *Telemetry) Result() interface{} {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
 	return self.result
 }
 
+// SetResult sets the result of the telemetry. It is ignored once the telemetry ended.
 func (self *Telemetry) SetResult(result interface{}) {
-	self.result = result
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.writable() {
+		self.result = result
+	}
 }
 
 func (self *Telemetry) Error() error {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
 	return self.err
 }
 
+// SetError sets the error of the telemetry. It is ignored once the telemetry ended.
 func (self *Telemetry) SetError(err error) {
-	self.err = err
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.writable() {
+		self.err = err
+	}
+}
+
+// Clock returns the clock reading the times of the telemetry, which handlers measuring it should
+// use too.
+func (self *Telemetry) Clock() clock.Clock {
+	return self.clock
 }
 
 func (self *Telemetry) StartTime() *time.Time {
 	return self.startTime
 }
 
+// EndTime returns when the telemetry ended, or nil while it is open.
 func (self *Telemetry) EndTime() *time.Time {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
 	return self.endTime
 }
 
+// DroppedChildren returns how many children were not attached because of Limits.MaxChildren.
+func (self *Telemetry) DroppedChildren() int64 {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return self.droppedChildren
+}
+
+// DroppedData returns how many values were not recorded because of Limits.MaxData.
+func (self *Telemetry) DroppedData() int64 {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return self.droppedData
+}
+
+// DroppedEvents returns how many events were not recorded because of Limits.MaxEvents.
+func (self *Telemetry) DroppedEvents() int64 {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return self.droppedEvents
+}
+
+// DroppedLinks returns how many links were not added because of Limits.MaxLinks.
+func (self *Telemetry) DroppedLinks() int64 {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return self.droppedLinks
+}
+
+// LateWrites returns how many values, baggage, events, links, results, errors and children were ignored because
+// they were given after the telemetry ended.
+func (self *Telemetry) LateWrites() int64 {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return self.lateWrites
+}
+
+func (self *Telemetry) Parent() *Telemetry {
+	return self.parent
+}
+
+// Children returns a copy of the attached children.
 func (self *Telemetry) Children() []*Telemetry {
-	return self.children
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	return append([]*Telemetry(nil), self.children...)
 }
@@ -1,14 +1,19 @@
 package telemetry
 
 import (
+	"errors"
+	"fmt"
+	"github.com/israelchen/gomon/clock"
 	"golang.org/x/net/context"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 type TestHandler struct {
 	startedHandler func(t *Telemetry)
-	endedHandler   func(t *Telemetry)
+	endedHandler   func(s *SpanData)
 }
 
 func (handler *TestHandler) Started(t *Telemetry) {
@@ -18,10 +23,10 @@ func (handler *TestHandler) Started(t *Telemetry) {
 	}
 }
 
-func (handler *TestHandler) Ended(t *Telemetry) {
+func (handler *TestHandler) Ended(s *SpanData) {
 
 	if handler.endedHandler != nil {
-		handler.endedHandler(t)
+		handler.endedHandler(s)
 	}
 }
 
@@ -46,15 +51,21 @@ func TestNewTelemetry(t *testing.T) {
 
 func TestElapsedWorksCorrectly(t *testing.T) {
 
-	ctx := NewTelemetry(context.Background(), "test.telemetry")
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	ctx := NewTelemetryWithOptions(context.Background(), "test.telemetry", WithClock(c))
+	nested := NewTelemetry(ctx, "test.telemetry.nested")
 
-	time.Sleep(50 * time.Millisecond)
+	c.Advance(50 * time.Millisecond)
 
 	// manually close telemetry
 	ctx.Close()
 
-	if ctx.EndTime().Sub(*ctx.StartTime()).Nanoseconds()/1e6 < 50 {
-		t.Error("Elapsed is less than expected.")
+	if ctx.EndTime().Sub(*ctx.StartTime()) != 50*time.Millisecond {
+		t.Error("Elapsed is different than expected.")
+	}
+
+	if nested.EndTime().Sub(*nested.StartTime()) != 50*time.Millisecond {
+		t.Error("Nested telemetry did not inherit the clock.")
 	}
 }
 
@@ -78,7 +89,7 @@ func TestCloseNestedDoesNotCloseBase(t *testing.T) {
 
 	baseCloseHandler := &TestHandler{
 
-		endedHandler: func(tel *Telemetry) {
+		endedHandler: func(s *SpanData) {
 			baseCloseCalled = true
 		},
 	}
@@ -89,7 +100,7 @@ func TestCloseNestedDoesNotCloseBase(t *testing.T) {
 
 	nestedCloseHandler := &TestHandler{
 
-		endedHandler: func(tel *Telemetry) {
+		endedHandler: func(s *SpanData) {
 			nestedCloseCalled = true
 		},
 	}
@@ -114,7 +125,7 @@ func TestCloseBaseAlsoClosesNested(t *testing.T) {
 
 	baseCloseHandler := &TestHandler{
 
-		endedHandler: func(tel *Telemetry) {
+		endedHandler: func(s *SpanData) {
 			baseCloseCalled = true
 		},
 	}
@@ -125,7 +136,7 @@ func TestCloseBaseAlsoClosesNested(t *testing.T) {
 
 	nestedCloseHandler := &TestHandler{
 
-		endedHandler: func(tel *Telemetry) {
+		endedHandler: func(s *SpanData) {
 			nestedCloseCalled = true
 		},
 	}
@@ -152,7 +163,7 @@ func TestTelemetryCanOnlyBeClosedOnce(t *testing.T) {
 
 	closeHandler := &TestHandler{
 
-		endedHandler: func(tel *Telemetry) {
+		endedHandler: func(s *SpanData) {
 			wasCloseCalled = true
 		},
 	}
@@ -182,7 +193,7 @@ func TestClosureCapturesBooleanByReference(t *testing.T) {
 
 	testHandler := &TestHandler{
 
-		endedHandler: func(tel *Telemetry) {
+		endedHandler: func(s *SpanData) {
 			wasCalled = true
 		},
 	}
@@ -201,3 +212,242 @@ func TestClosureCapturesBooleanByReference(t *testing.T) {
 		t.Fatal("was not set the second time.")
 	}
 }
+
+func TestLimitsDropChildrenAndData(t *testing.T) {
+
+	base := NewTelemetryWithOptions(context.Background(), "test.telemetry.base", WithLimits(Limits{MaxChildren: 2, MaxData: 1, MaxEvents: 2, MaxLinks: 1}))
+	other := NewTelemetry(context.Background(), "test.telemetry.other")
+
+	for i := 0; i < 5; i++ {
+		base.AddEvent("tick", "i", i)
+		base.AddLink(other.SpanContext(), "i", i)
+		nested := NewTelemetry(base, "test.telemetry.nested")
+
+		// nested telemetries inherit the limits of their parent
+		nested.RecordValue("a", i)
+		nested.RecordValue("b", i)
+
+		if nested.DroppedData() != 1 {
+			t.Fatal("nested did not inherit MaxData.")
+		}
+	}
+
+	if len(base.Children()) != 2 {
+		t.Fatalf("Expected 2 children, got %d.", len(base.Children()))
+	}
+
+	if base.DroppedChildren() != 3 {
+		t.Fatalf("Expected 3 dropped children, got %d.", base.DroppedChildren())
+	}
+
+	if len(base.Events()) != 2 || base.DroppedEvents() != 3 || base.Snapshot().DroppedEvents != 3 {
+		t.Fatalf("Expected 2 events and 3 dropped, got %d and %d.", len(base.Events()), base.DroppedEvents())
+	}
+
+	if len(base.Links()) != 1 || base.DroppedLinks() != 4 || base.Snapshot().DroppedLinks != 4 {
+		t.Fatalf("Expected 1 link and 4 dropped, got %d and %d.", len(base.Links()), base.DroppedLinks())
+	}
+}
+
+func TestDetachClosedChildren(t *testing.T) {
+
+	var endedCalled bool
+
+	handler := &TestHandler{
+
+		endedHandler: func(s *SpanData) {
+			endedCalled = true
+		},
+	}
+
+	base := NewTelemetryWithOptions(context.Background(), "test.telemetry.base", WithLimits(Limits{DetachClosedChildren: true}))
+
+	nested := NewTelemetry(base, "test.telemetry.nested", handler)
+	open := NewTelemetry(base, "test.telemetry.open")
+
+	nested.Close()
+
+	if endedCalled == false {
+		t.Fatal("nested close was not called.")
+	}
+
+	if len(base.Children()) != 1 || base.Children()[0] != open {
+		t.Fatal("closed nested was not detached from base.")
+	}
+
+	// children closed by closing base stay attached for base's handlers
+	base.Close()
+
+	if len(base.Children()) != 1 {
+		t.Fatal("open nested was detached when closing base.")
+	}
+}
+
+func TestSnapshotIsImmutable(t *testing.T) {
+
+	var ended []*SpanData
+
+	handler := &TestHandler{
+
+		endedHandler: func(s *SpanData) {
+			ended = append(ended, s)
+		},
+	}
+
+	base := NewTelemetry(context.Background(), "test.telemetry.base", handler)
+	nested := NewTelemetry(base, "test.telemetry.nested", handler)
+	nested.RecordValue("rows", 3)
+
+	nested.Close()
+	base.Close()
+
+	if len(ended) != 2 || ended[0] != nested.Snapshot() || ended[1] != base.Snapshot() {
+		t.Fatal("Handlers were not given the stored snapshots.")
+	}
+
+	// closed children's snapshots are reused by their parent's
+	if len(ended[1].Children) != 1 || ended[1].Children[0] != ended[0] {
+		t.Fatal("nested snapshot was not reused by base.")
+	}
+
+	if ended[0].Path != "test.telemetry.base/test.telemetry.nested" || ended[0].ParentID != base.ID() || !ended[0].Ended {
+		t.Errorf("nested snapshot is different than expected: %+v.", ended[0])
+	}
+
+	nested.RecordValue("rows", 4)
+	NewTelemetry(base, "test.telemetry.late")
+
+	if ended[0].Data["rows"] != 3 || len(ended[1].Children) != 1 {
+		t.Error("Snapshots changed after the telemetries were closed.")
+	}
+}
+
+func TestEndWithEndTime(t *testing.T) {
+
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	base := NewTelemetryWithOptions(context.Background(), "test.telemetry.base", WithClock(c))
+
+	c.Advance(10 * time.Millisecond)
+	nested := NewTelemetry(base, "test.telemetry.nested")
+	c.Advance(time.Second)
+	late := NewTelemetry(base, "test.telemetry.late")
+
+	base.End(WithEndTime(base.StartTime().Add(20 * time.Millisecond)))
+
+	if base.EndTime().Sub(*base.StartTime()) != 20*time.Millisecond || !base.Ended() {
+		t.Errorf("base end time is different than expected: %v.", base.EndTime())
+	}
+
+	// open children end with their parent, but not before they started
+	if !nested.EndTime().Equal(*base.EndTime()) || !late.EndTime().Equal(*late.StartTime()) {
+		t.Errorf("children end times are different than expected: %v, %v.", nested.EndTime(), late.EndTime())
+	}
+
+	// ending again does nothing
+	base.End()
+
+	if base.EndTime().Sub(*base.StartTime()) != 20*time.Millisecond {
+		t.Error("base was ended twice.")
+	}
+}
+
+func TestWritesAfterEndAreIgnored(t *testing.T) {
+
+	ctx := NewTelemetry(context.Background(), "test.telemetry")
+	ctx.SetError(errors.New("boom"))
+	ctx.Close()
+
+	ctx.SetError(nil)
+	ctx.SetResult(1)
+	ctx.RecordValue("a", 1)
+	ctx.AddEvent("late")
+	NewTelemetry(ctx, "test.telemetry.late").Close()
+
+	if ctx.Error() == nil || ctx.Result() != nil || len(ctx.Data()) != 0 || len(ctx.Events()) != 0 || len(ctx.Children()) != 0 {
+		t.Error("Writes after end were applied.")
+	}
+
+	if ctx.LateWrites() != 5 {
+		t.Errorf("Expected 5 late writes, got %d.", ctx.LateWrites())
+	}
+}
+
+func TestConcurrentLifecycle(t *testing.T) {
+
+	var ended int64
+
+	handler := &TestHandler{
+
+		endedHandler: func(s *SpanData) {
+			atomic.AddInt64(&ended, 1)
+		},
+	}
+
+	for i := 0; i < 20; i++ {
+		base := NewTelemetry(context.Background(), "test.telemetry.base", handler)
+
+		var wg sync.WaitGroup
+
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+
+			go func(j int) {
+				defer wg.Done()
+
+				var created []*Telemetry
+
+				// nested created after base ended are not attached, so close them all ourselves
+				defer func() {
+					for _, nested := range created {
+						nested.Close()
+					}
+				}()
+
+				for k := 0; k < 20; k++ {
+					nested := NewTelemetry(base, "test.telemetry.nested", handler)
+					created = append(created, nested)
+					nested.RecordValue(fmt.Sprint(j), k)
+					nested.AddEvent("step", "k", k)
+					nested.SetResult(k)
+					base.SetError(fmt.Errorf("nested %d", k))
+					base.RecordValue(j, k)
+
+					for _, child := range base.Children() {
+						child.Error()
+						child.EndTime()
+					}
+
+					base.Snapshot()
+
+					if k%3 == 0 {
+						nested.Close()
+					}
+
+					if j == 0 && k == 10 {
+						base.Close()
+					}
+				}
+			}(j)
+		}
+
+		wg.Wait()
+		base.Close()
+
+		span := base.Snapshot()
+
+		if !span.Ended || span != base.Snapshot() {
+			t.Fatal("base snapshot is different than expected.")
+		}
+
+		for _, child := range span.Children {
+			if !child.Ended || child.EndTime.After(span.EndTime) {
+				t.Fatalf("child %d did not end within base.", child.ID)
+			}
+		}
+	}
+
+	// every telemetry ended exactly once: 20 bases with 8 * 20 nested each
+	if ended != 20*(1+8*20) {
+		t.Errorf("Expected %d ends, got %d.", 20*(1+8*20), ended)
+	}
+}
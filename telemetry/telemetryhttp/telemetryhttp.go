@@ -0,0 +1,81 @@
+// Package telemetryhttp runs HTTP requests within telemetries and propagates the telemetry baggage
+// between processes with the W3C baggage header, on the server side:
+//
+//	http.Handle("/orders", telemetryhttp.NewHandler("orders", orders, tracker))
+//
+// and on the client side, for requests made with a context holding a telemetry:
+//
+//	client := &http.Client{Transport: &telemetryhttp.Transport{}}
+package telemetryhttp
+
+import (
+	"github.com/israelchen/gomon/telemetry"
+	"github.com/israelchen/gomon/util"
+	"net/http"
+	"strings"
+)
+
+// BaggageHeader is the header carrying the baggage, as defined by W3C Baggage.
+const BaggageHeader = "baggage"
+
+// NewHandler returns a handler serving each request with next within a telemetry named name,
+// which is given the handlers and the baggage received in the baggage header. The telemetry is the
+// context of the request next is given, and ends when next returns.
+func NewHandler(name string, next http.Handler, handlers ...telemetry.Handler) http.Handler {
+	util.Require(len(name) > 0, "telemetryhttp: name cannot be empty.")
+	util.Require(next != nil, "telemetryhttp: next cannot be nil.")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := telemetry.NewTelemetryWithOptions(r.Context(), name,
+			telemetry.WithHandlers(handlers...),
+			telemetry.WithBaggage(readBaggage(r.Header)))
+
+		defer t.Close()
+
+		next.ServeHTTP(w, r.WithContext(t))
+	})
+}
+
+// Transport is an http.RoundTripper sending the baggage of the telemetry in the context of each
+// request in its baggage header. Baggage already set in the header takes precedence over the
+// telemetry's. Its zero value sends requests with http.DefaultTransport.
+type Transport struct {
+	// Base sends the requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+func (self *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	base := self.Base
+
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t, ok := telemetry.FromContext(r.Context())
+
+	if !ok {
+		return base.RoundTrip(r)
+	}
+
+	baggage := t.Baggage()
+
+	if len(baggage) == 0 {
+		return base.RoundTrip(r)
+	}
+
+	for key, value := range readBaggage(r.Header) {
+		baggage[key] = value
+	}
+
+	// round trippers must not modify the request they are given
+	r = r.Clone(r.Context())
+	r.Header.Set(BaggageHeader, telemetry.FormatBaggage(baggage))
+
+	return base.RoundTrip(r)
+}
+
+// readBaggage parses the baggage headers, which may be repeated.
+func readBaggage(header http.Header) map[string]string {
+	return telemetry.ParseBaggage(strings.Join(header.Values(BaggageHeader), ","))
+}
@@ -0,0 +1,59 @@
+package telemetryhttp
+
+import (
+	"github.com/israelchen/gomon/telemetry"
+	"github.com/israelchen/gomon/telemetry/telemetrytest"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBaggageRoundTrip(t *testing.T) {
+
+	recorder := telemetrytest.NewRecorder()
+
+	var received string
+
+	downstream := httptest.NewServer(NewHandler("downstream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(BaggageHeader)
+
+		if ctx, ok := telemetry.FromContext(r.Context()); ok {
+			ctx.SetBaggage("seen", "yes")
+		}
+	}), recorder))
+
+	defer downstream.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+
+	upstream := telemetry.NewTelemetryWithOptions(context.Background(), "upstream", telemetry.WithBaggage(map[string]string{"tenant": "acme", "user": "1"}))
+	call := telemetry.NewTelemetry(upstream, "call")
+	call.SetBaggage("attempt", "2")
+
+	request, _ := http.NewRequestWithContext(call, "GET", downstream.URL, nil)
+	request.Header.Set(BaggageHeader, "user=2")
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response.Body.Close()
+	upstream.Close()
+
+	if received != "attempt=2,tenant=acme,user=2" {
+		t.Errorf("Header is different than expected: %s.", received)
+	}
+
+	if request.Header.Get(BaggageHeader) != "user=2" {
+		t.Error("Transport modified the request.")
+	}
+
+	s := telemetrytest.AssertRecorded(t, recorder, "downstream", 1)[0]
+
+	if len(s.Baggage) != 4 || s.Baggage["tenant"] != "acme" || s.Baggage["user"] != "2" || s.Baggage["seen"] != "yes" {
+		t.Errorf("Baggage is different than expected: %v.", s.Baggage)
+	}
+}
@@ -0,0 +1,245 @@
+// Package telemetrytest helps asserting in tests on what code instrumented with telemetry
+// recorded, e.g. that an operation was recorded once with a given error:
+//
+//	recorder := telemetrytest.NewRecorder()
+//	ctx := telemetry.NewTelemetry(context.Background(), "handler", recorder)
+//	serve(ctx)
+//	ctx.Close()
+//
+//	s := telemetrytest.AssertRecorded(t, recorder, "handler", 1)[0]
+//	telemetrytest.AssertError(t, s, sql.ErrNoRows)
+//	telemetrytest.AssertTree(t, s, "handler\n  query\n    dial")
+package telemetrytest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/israelchen/gomon/telemetry"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Find returns the telemetries of the tree rooted at span, itself included, with the given name or
+// path.
+func Find(span *telemetry.SpanData, nameOrPath string) []*telemetry.SpanData {
+
+	var found []*telemetry.SpanData
+
+	if span.Name == nameOrPath || span.Path == nameOrPath {
+		found = append(found, span)
+	}
+
+	for _, child := range span.Children {
+		found = append(found, Find(child, nameOrPath)...)
+	}
+
+	return found
+}
+
+// Shape renders the names of the tree rooted at span, one per line and indented by two spaces per
+// level.
+func Shape(span *telemetry.SpanData) string {
+
+	var lines []string
+	var walk func(s *telemetry.SpanData, depth int)
+
+	walk = func(s *telemetry.SpanData, depth int) {
+		lines = append(lines, strings.Repeat("  ", depth)+s.Name)
+
+		for _, child := range s.Children {
+			walk(child, depth+1)
+		}
+	}
+
+	walk(span, 0)
+	return strings.Join(lines, "\n")
+}
+
+// Recorder is a telemetry handler keeping a snapshot of every telemetry it is called for, when it
+// starts and when it ends. The snapshots taken at the end hold the snapshots of the children
+// attached at that time.
+type Recorder struct {
+	started []*telemetry.SpanData
+	ended   []*telemetry.SpanData
+	changed chan struct{}
+	mu      sync.Mutex
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		changed: make(chan struct{}),
+	}
+}
+
+func (self *Recorder) Started(t *telemetry.Telemetry) {
+
+	s := t.Snapshot()
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.started = append(self.started, s)
+}
+
+func (self *Recorder) Ended(span *telemetry.SpanData) {
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.ended = append(self.ended, span)
+
+	// wake up the waiters
+	close(self.changed)
+	self.changed = make(chan struct{})
+}
+
+// StartedSnapshots returns the snapshots taken when telemetries started, in order.
+func (self *Recorder) StartedSnapshots() []*telemetry.SpanData {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return append([]*telemetry.SpanData(nil), self.started...)
+}
+
+// EndedSnapshots returns the snapshots taken when telemetries ended, in order.
+func (self *Recorder) EndedSnapshots() []*telemetry.SpanData {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return append([]*telemetry.SpanData(nil), self.ended...)
+}
+
+// Find returns the snapshots of the ended telemetries with the given name or path, in the order
+// they ended. The descendants of the telemetries the recorder was a handler of are searched too,
+// each telemetry being returned once.
+func (self *Recorder) Find(nameOrPath string) []*telemetry.SpanData {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	var found []*telemetry.SpanData
+	seen := make(map[uint64]bool)
+
+	for _, span := range self.ended {
+		for _, s := range Find(span, nameOrPath) {
+			if !seen[s.ID] {
+				seen[s.ID] = true
+				found = append(found, s)
+			}
+		}
+	}
+
+	return found
+}
+
+// Wait waits until count telemetries with the given name or path have ended, e.g. when they are
+// closed by other goroutines, and returns their snapshots. It returns an error when they did not
+// end within the timeout.
+func (self *Recorder) Wait(nameOrPath string, count int, timeout time.Duration) ([]*telemetry.SpanData, error) {
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		self.mu.Lock()
+		changed := self.changed
+		self.mu.Unlock()
+
+		if found := self.Find(nameOrPath); len(found) >= count {
+			return found, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			found := self.Find(nameOrPath)
+			return found, fmt.Errorf("telemetrytest: %d of %d %q telemetries ended within %s", len(found), count, nameOrPath, timeout)
+		}
+	}
+}
+
+// Reset forgets the snapshots taken so far.
+func (self *Recorder) Reset() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.started = nil
+	self.ended = nil
+}
+
+// AssertRecorded fails the test unless exactly count telemetries with the given name or path
+// ended, and returns their snapshots.
+func AssertRecorded(t testing.TB, recorder *Recorder, nameOrPath string, count int) []*telemetry.SpanData {
+	t.Helper()
+
+	found := recorder.Find(nameOrPath)
+
+	if len(found) != count {
+		t.Errorf("Expected %q to be recorded %d times, was recorded %d times.", nameOrPath, count, len(found))
+	}
+
+	return found
+}
+
+// AssertEventually waits for count telemetries with the given name or path to end, failing the
+// test if they did not within the timeout, and returns their snapshots.
+func AssertEventually(t testing.TB, recorder *Recorder, nameOrPath string, count int, timeout time.Duration) []*telemetry.SpanData {
+	t.Helper()
+
+	found, err := recorder.Wait(nameOrPath, count, timeout)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	return found
+}
+
+// AssertError fails the test unless the telemetry ended with an error matching target, as
+// reported by errors.Is.
+func AssertError(t testing.TB, s *telemetry.SpanData, target error) {
+	t.Helper()
+
+	if !errors.Is(s.Err, target) {
+		t.Errorf("Expected %q to fail with %v, got %v.", s.Path, target, s.Err)
+	}
+}
+
+// AssertNoError fails the test if the telemetry ended with an error.
+func AssertNoError(t testing.TB, s *telemetry.SpanData) {
+	t.Helper()
+
+	if s.Err != nil {
+		t.Errorf("Expected %q not to fail, got %v.", s.Path, s.Err)
+	}
+}
+
+// AssertValue fails the test unless the telemetry recorded the expected value for key.
+func AssertValue(t testing.TB, s *telemetry.SpanData, key interface{}, expected interface{}) {
+	t.Helper()
+
+	value, ok := s.Data[key]
+
+	if !ok {
+		t.Errorf("Expected %q to record %v, it did not.", s.Path, key)
+		return
+	}
+
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("Expected %q to record %v = %v, got %v.", s.Path, key, expected, value)
+	}
+}
+
+// AssertTree fails the test unless the tree rooted at the snapshot has the expected shape, as
+// rendered by Shape. Leading and trailing blank lines of expected are ignored.
+func AssertTree(t testing.TB, s *telemetry.SpanData, expected string) {
+	t.Helper()
+
+	expected = strings.Trim(expected, "\n")
+
+	if shape := Shape(s); shape != expected {
+		t.Errorf("Tree of %q is different than expected.\ngot:\n%s\nexpected:\n%s", s.Path, shape, expected)
+	}
+}
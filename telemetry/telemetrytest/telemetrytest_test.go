@@ -0,0 +1,121 @@
+package telemetrytest
+
+import (
+	"errors"
+	"github.com/israelchen/gomon/telemetry"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+var errNotFound = errors.New("not found")
+
+func TestRecorder(t *testing.T) {
+
+	recorder := NewRecorder()
+
+	root := telemetry.NewTelemetry(context.Background(), "handler", recorder)
+	root.RecordValue("user", "alice")
+
+	query := telemetry.NewTelemetry(root, "query", recorder)
+	telemetry.NewTelemetry(query, "dial")
+	query.SetError(errNotFound)
+	query.Close()
+
+	telemetry.NewTelemetry(root, "render")
+	root.Close()
+
+	// later changes do not affect the snapshots.
+	root.RecordValue("user", "bob")
+
+	if started := recorder.StartedSnapshots(); len(started) != 2 || started[0].Ended || started[1].Path != "handler/query" {
+		t.Errorf("Started snapshots are different than expected: %v.", started)
+	}
+
+	s := AssertRecorded(t, recorder, "handler", 1)[0]
+
+	AssertNoError(t, s)
+	AssertValue(t, s, "user", "alice")
+	AssertTree(t, s, `
+handler
+  query
+    dial
+  render
+`)
+
+	q := AssertRecorded(t, recorder, "handler/query", 1)[0]
+
+	AssertError(t, q, errNotFound)
+
+	if q.ParentID != s.ID || q.TraceID != s.TraceID || len(Find(s, "dial")) != 1 || Find(s, "handler/render")[0].Elapsed() < 0 {
+		t.Errorf("Snapshots are different than expected: %+v, %+v.", s, q)
+	}
+
+	// descendants the recorder was not a handler of are found in the trees it recorded, and those
+	// it was are found once.
+	if d := AssertRecorded(t, recorder, "dial", 1); len(d) == 1 && d[0].Path != "handler/query/dial" {
+		t.Errorf("Found %q instead of handler/query/dial.", d[0].Path)
+	}
+
+	recorder.Reset()
+
+	if len(recorder.EndedSnapshots()) != 0 {
+		t.Error("Reset did not forget the snapshots.")
+	}
+}
+
+func TestAssertionsFail(t *testing.T) {
+
+	recorder := NewRecorder()
+	telemetry.NewTelemetry(context.Background(), "handler", recorder).Close()
+
+	s := recorder.Find("handler")[0]
+
+	for name, assert := range map[string]func(tb testing.TB){
+		"recorded": func(tb testing.TB) { AssertRecorded(tb, recorder, "handler", 2) },
+		"error":    func(tb testing.TB) { AssertError(tb, s, errNotFound) },
+		"value":    func(tb testing.TB) { AssertValue(tb, s, "user", "alice") },
+		"tree":     func(tb testing.TB) { AssertTree(tb, s, "handler\n  query") },
+	} {
+		fake := &fakeTB{TB: t}
+		assert(fake)
+
+		if !fake.failed {
+			t.Errorf("Expected the %s assertion to fail.", name)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+
+	recorder := NewRecorder()
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			telemetry.NewTelemetry(context.Background(), "job", recorder).Close()
+		}()
+	}
+
+	AssertEventually(t, recorder, "job", 3, 5*time.Second)
+
+	if _, err := recorder.Wait("job", 4, 50*time.Millisecond); err == nil {
+		t.Error("Expected waiting for a fourth job to time out.")
+	}
+}
+
+// fakeTB records failures instead of failing the test.
+type fakeTB struct {
+	testing.TB
+	failed bool
+}
+
+func (self *fakeTB) Helper() {}
+
+func (self *fakeTB) Errorf(format string, args ...interface{}) {
+	self.failed = true
+}
+
+func (self *fakeTB) Error(args ...interface{}) {
+	self.failed = true
+}
@@ -0,0 +1,206 @@
+package telemetry
+
+import (
+	"github.com/israelchen/gomon/clock"
+	"github.com/israelchen/gomon/util"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThresholdAlert describes a telemetry that exceeded its latency budget.
+type ThresholdAlert struct {
+	// Span is the snapshot of the telemetry, taken when it overran for open telemetries.
+	Span      *SpanData
+	Pattern   string
+	Threshold time.Duration
+	Elapsed   time.Duration
+
+	// Open is true when the telemetry is still open past its budget, and false once it closed over
+	// budget. A telemetry that overruns while open is reported again when it closes.
+	Open bool
+}
+
+type ThresholdCallback func(alert *ThresholdAlert)
+
+// ThresholdHandler reports telemetries that take longer than their latency budget, both when
+// they close and while they are still open. Budgets are given per telemetry name, either exactly
+// or as path.Match glob patterns such as "db.*"; an exact name takes precedence over patterns,
+// and longer patterns over shorter ones.
+//
+// Alerts go to a callback if one is set, otherwise to a slog.Logger as a warning including the
+// rendered child tree. Only telemetries the handler is attached to are checked.
+type ThresholdHandler struct {
+	exact    map[string]time.Duration
+	patterns []string
+	budgets  map[string]time.Duration
+	callback ThresholdCallback
+	logger   *slog.Logger
+	timers   map[uint64]clock.Timer
+	mu       sync.Mutex
+}
+
+type ThresholdHandlerOption func(handler *ThresholdHandler)
+
+func WithThresholdCallback(callback ThresholdCallback) ThresholdHandlerOption {
+	util.Require(callback != nil, "telemetry: callback cannot be nil.")
+
+	return func(handler *ThresholdHandler) {
+		handler.callback = callback
+	}
+}
+
+// WithThresholdLogger sets the logger alerts are written to when there is no callback. The
+// default is slog.Default().
+func WithThresholdLogger(logger *slog.Logger) ThresholdHandlerOption {
+	util.Require(logger != nil, "telemetry: logger cannot be nil.")
+
+	return func(handler *ThresholdHandler) {
+		handler.logger = logger
+	}
+}
+
+func NewThresholdHandler(thresholds map[string]time.Duration, options ...ThresholdHandlerOption) *ThresholdHandler {
+	util.Require(len(thresholds) > 0, "telemetry: thresholds cannot be empty.")
+
+	handler := &ThresholdHandler{
+		exact:   make(map[string]time.Duration),
+		budgets: make(map[string]time.Duration),
+		timers:  make(map[uint64]clock.Timer),
+	}
+
+	for pattern, threshold := range thresholds {
+		util.Require(threshold > 0, "telemetry: thresholds must be positive.")
+
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			handler.exact[pattern] = threshold
+			continue
+		}
+
+		_, err := path.Match(pattern, "")
+		util.Require(err == nil, "telemetry: invalid threshold pattern "+pattern+".")
+
+		handler.patterns = append(handler.patterns, pattern)
+		handler.budgets[pattern] = threshold
+	}
+
+	sort.Slice(handler.patterns, func(i, j int) bool {
+		if len(handler.patterns[i]) != len(handler.patterns[j]) {
+			return len(handler.patterns[i]) > len(handler.patterns[j])
+		}
+
+		return handler.patterns[i] < handler.patterns[j]
+	})
+
+	for _, option := range options {
+		option(handler)
+	}
+
+	if handler.logger == nil {
+		handler.logger = slog.Default()
+	}
+
+	return handler
+}
+
+// Threshold returns the budget of the named telemetry and the pattern it was matched by.
+func (self *ThresholdHandler) Threshold(name string) (string, time.Duration, bool) {
+
+	if threshold, ok := self.exact[name]; ok {
+		return name, threshold, true
+	}
+
+	for _, pattern := range self.patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return pattern, self.budgets[pattern], true
+		}
+	}
+
+	return "", 0, false
+}
+
+func (self *ThresholdHandler) Started(t *Telemetry) {
+	util.Require(t != nil, "telemetry: telemetry cannot be nil.")
+
+	pattern, threshold, ok := self.Threshold(t.Name())
+
+	if !ok {
+		return
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	c := t.Clock()
+
+	// the timer cannot fire before it is registered, as firing takes the lock held here
+	self.timers[t.ID()] = c.AfterFunc(threshold-c.Now().Sub(*t.StartTime()), func() {
+		self.mu.Lock()
+		_, open := self.timers[t.ID()]
+		delete(self.timers, t.ID())
+		self.mu.Unlock()
+
+		if open {
+			span := t.Snapshot()
+			self.alert(&ThresholdAlert{span, pattern, threshold, span.Elapsed(), true})
+		}
+	})
+}
+
+func (self *ThresholdHandler) Ended(span *SpanData) {
+	util.Require(span != nil, "telemetry: span cannot be nil.")
+
+	pattern, threshold, ok := self.Threshold(span.Name)
+
+	if !ok {
+		return
+	}
+
+	self.mu.Lock()
+
+	if timer, ok := self.timers[span.ID]; ok {
+		timer.Stop()
+		delete(self.timers, span.ID)
+	}
+
+	self.mu.Unlock()
+
+	if elapsed := span.Elapsed(); elapsed > threshold {
+		self.alert(&ThresholdAlert{span, pattern, threshold, elapsed, false})
+	}
+}
+
+func (self *ThresholdHandler) alert(alert *ThresholdAlert) {
+
+	if self.callback != nil {
+		self.callback(alert)
+		return
+	}
+
+	var tree strings.Builder
+	WriteTreeText(&tree, AnalyzeTree(alert.Span, 0))
+
+	message := "telemetry exceeded its latency budget"
+
+	if alert.Open {
+		message = "telemetry still open past its latency budget"
+	}
+
+	attrs := []interface{}{
+		slog.String("name", alert.Span.Name),
+		slog.Uint64("id", alert.Span.ID),
+		slog.String("pattern", alert.Pattern),
+		slog.Duration("threshold", alert.Threshold),
+		slog.Duration("elapsed", alert.Elapsed),
+		slog.String("tree", tree.String()),
+	}
+
+	if alert.Span.Err != nil {
+		attrs = append(attrs, slog.String("error", alert.Span.Err.Error()))
+	}
+
+	self.logger.Warn(message, attrs...)
+}
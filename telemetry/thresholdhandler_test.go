@@ -0,0 +1,130 @@
+package telemetry
+
+import (
+	"bytes"
+	"github.com/israelchen/gomon/clock"
+	"golang.org/x/net/context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThresholdMatchesExactNamesBeforePatterns(t *testing.T) {
+
+	handler := NewThresholdHandler(map[string]time.Duration{
+		"db.query":     time.Second,
+		"db.*":         2 * time.Second,
+		"db.query.*":   3 * time.Second,
+		"*":            4 * time.Second,
+		"http.[a-z]et": 5 * time.Second,
+	})
+
+	tests := []struct {
+		name      string
+		pattern   string
+		threshold time.Duration
+	}{
+		{"db.query", "db.query", time.Second},
+		{"db.insert", "db.*", 2 * time.Second},
+		{"db.query.rows", "db.query.*", 3 * time.Second},
+		{"http.get", "http.[a-z]et", 5 * time.Second},
+		{"render", "*", 4 * time.Second},
+	}
+
+	for _, test := range tests {
+		if pattern, threshold, ok := handler.Threshold(test.name); !ok || pattern != test.pattern || threshold != test.threshold {
+			t.Errorf("Expected %s to match %s (%v), got %s (%v).", test.name, test.pattern, test.threshold, pattern, threshold)
+		}
+	}
+}
+
+func TestThresholdHandlerAlertsOpenAndClosed(t *testing.T) {
+
+	alerts := make(chan *ThresholdAlert, 2)
+
+	handler := NewThresholdHandler(map[string]time.Duration{"test.slow": 20 * time.Millisecond, "test.fast": time.Hour},
+		WithThresholdCallback(func(alert *ThresholdAlert) {
+			alerts <- alert
+		}))
+
+	NewTelemetry(context.Background(), "test.fast", handler).Close()
+	slow := NewTelemetry(context.Background(), "test.slow", handler)
+
+	select {
+	case alert := <-alerts:
+		if alert.Span.ID != slow.ID() || !alert.Open || alert.Elapsed < 20*time.Millisecond {
+			t.Errorf("Open alert is different than expected: %+v.", alert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow was not reported while open.")
+	}
+
+	slow.Close()
+
+	select {
+	case alert := <-alerts:
+		if alert.Span.ID != slow.ID() || alert.Open {
+			t.Errorf("Closed alert is different than expected: %+v.", alert)
+		}
+	default:
+		t.Fatal("slow was not reported when closed.")
+	}
+}
+
+func TestThresholdHandlerLogsTree(t *testing.T) {
+
+	var b bytes.Buffer
+
+	handler := NewThresholdHandler(map[string]time.Duration{"root": time.Millisecond},
+		WithThresholdLogger(slog.New(slog.NewJSONHandler(&b, nil))))
+
+	handler.Ended(newTestTree().Snapshot())
+
+	for _, expected := range []string{`"level":"WARN"`, `"name":"root"`, `"threshold":1000000`, `render`} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Log record does not contain %s:\n%s", expected, b.String())
+		}
+	}
+}
+
+func TestThresholdHandlerUsesTelemetryClock(t *testing.T) {
+
+	var alerts []*ThresholdAlert
+
+	handler := NewThresholdHandler(map[string]time.Duration{"test.slow": time.Minute},
+		WithThresholdCallback(func(alert *ThresholdAlert) {
+			alerts = append(alerts, alert)
+		}))
+
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	slow := NewTelemetryWithOptions(context.Background(), "test.slow", WithHandlers(handler), WithClock(c))
+
+	c.Advance(59 * time.Second)
+
+	if len(alerts) != 0 {
+		t.Fatal("slow was reported before its budget elapsed on its clock.")
+	}
+
+	c.Advance(2 * time.Second)
+
+	if len(alerts) != 1 || !alerts[0].Open || alerts[0].Elapsed != 61*time.Second {
+		t.Fatalf("Open alert is different than expected: %v.", alerts)
+	}
+
+	c.Advance(time.Second)
+	slow.Close()
+
+	if len(alerts) != 2 || alerts[1].Open || alerts[1].Elapsed != 62*time.Second {
+		t.Errorf("Closed alert is different than expected: %+v.", alerts[1])
+	}
+
+	// a closed telemetry's timer is stopped
+	fast := NewTelemetryWithOptions(context.Background(), "test.slow", WithHandlers(handler), WithClock(c))
+	fast.Close()
+	c.Advance(time.Hour)
+
+	if len(alerts) != 2 {
+		t.Error("fast was reported after it closed.")
+	}
+}
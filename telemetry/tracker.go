@@ -0,0 +1,177 @@
+package telemetry
+
+import (
+	"fmt"
+	"github.com/israelchen/gomon/util"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracker is an opt-in handler keeping track of the telemetries it is attached to while they are
+// open. It is meant to be attached to root telemetries and mounted as an http.Handler, typically
+// on /debug/telemetry, to find out which operations a hanging process is stuck in.
+//
+// The page lists the open roots with their child trees and accepts two query parameters: name,
+// which filters roots whose name contains it, and minAge, a duration such as 500ms or 1m that
+// filters out roots opened more recently.
+type Tracker struct {
+	active map[uint64]*Telemetry
+	mu     sync.Mutex
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		active: make(map[uint64]*Telemetry),
+	}
+}
+
+func (self *Tracker) Started(t *Telemetry) {
+	util.Require(t != nil, "telemetry: telemetry cannot be nil.")
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.active[t.ID()] = t
+}
+
+func (self *Tracker) Ended(span *SpanData) {
+	util.Require(span != nil, "telemetry: span cannot be nil.")
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	delete(self.active, span.ID)
+}
+
+// Active returns the open telemetries, oldest first.
+func (self *Tracker) Active() []*Telemetry {
+
+	self.mu.Lock()
+
+	active := make([]*Telemetry, 0, len(self.active))
+
+	for _, t := range self.active {
+		active = append(active, t)
+	}
+
+	self.mu.Unlock()
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].StartTime().Before(*active[j].StartTime())
+	})
+
+	return active
+}
+
+type trackerNode struct {
+	ID       uint64
+	Name     string
+	Started  time.Time
+	Elapsed  time.Duration
+	Open     bool
+	Error    string
+	Data     []string
+	Links    []string
+	Children []*trackerNode
+}
+
+func newTrackerNode(span *SpanData) *trackerNode {
+
+	node := &trackerNode{
+		ID:      span.ID,
+		Name:    span.Name,
+		Started: span.StartTime,
+		Elapsed: span.Elapsed(),
+		Open:    !span.Ended,
+	}
+
+	if span.Err != nil {
+		node.Error = span.Err.Error()
+	}
+
+	for k, v := range span.Data {
+		node.Data = append(node.Data, fmt.Sprintf("%v=%v", k, v))
+	}
+
+	sort.Strings(node.Data)
+
+	for _, link := range span.Links {
+		node.Links = append(node.Links, strings.Join(append([]string{link.String()}, formatAttributes(link.Attributes)...), " "))
+	}
+
+	for _, child := range span.Children {
+		node.Children = append(node.Children, newTrackerNode(child))
+	}
+
+	return node
+}
+
+// formatAttributes formats attributes as sorted key=value strings.
+func formatAttributes(attributes map[string]interface{}) []string {
+
+	formatted := make([]string, 0, len(attributes))
+
+	for k, v := range attributes {
+		formatted = append(formatted, fmt.Sprintf("%s=%v", k, v))
+	}
+
+	sort.Strings(formatted)
+
+	return formatted
+}
+
+var trackerTemplate = template.Must(template.New("tracker").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Active telemetries</title></head>
+<body style="font-family: monospace">
+<form method="GET">
+name <input name="name" value="{{.Name}}"> min age <input name="minAge" value="{{.MinAge}}"> <input type="submit" value="filter">
+</form>
+<p>{{len .Roots}} active telemetries.</p>
+{{define "node"}}<li>#{{.ID}} <b>{{.Name}}</b> {{if .Open}}open for{{else}}took{{end}} {{.Elapsed}}{{if .Error}} <span style="color: red">error: {{.Error}}</span>{{end}}{{range .Data}} [{{.}}]{{end}}
+{{if .Links}}<ul>{{range .Links}}<li>&rarr; link {{.}}</li>{{end}}</ul>{{end}}
+{{if .Children}}<ul>{{range .Children}}{{template "node" .}}{{end}}</ul>{{end}}</li>
+{{end}}<ul>{{range .Roots}}{{template "node" .}}{{end}}</ul>
+</body>
+</html>
+`))
+
+func (self *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	name := r.FormValue("name")
+
+	var minAge time.Duration
+
+	if value := r.FormValue("minAge"); len(value) > 0 {
+		var err error
+
+		if minAge, err = time.ParseDuration(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid minAge: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	page := struct {
+		Name   string
+		MinAge string
+		Roots  []*trackerNode
+	}{
+		Name:   name,
+		MinAge: r.FormValue("minAge"),
+	}
+
+	for _, t := range self.Active() {
+		if !strings.Contains(t.Name(), name) || t.Clock().Now().Sub(*t.StartTime()) < minAge {
+			continue
+		}
+
+		page.Roots = append(page.Roots, newTrackerNode(t.Snapshot()))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	trackerTemplate.Execute(w, page)
+}
@@ -0,0 +1,52 @@
+package telemetry
+
+import (
+	"github.com/israelchen/gomon/clock"
+	"golang.org/x/net/context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackerListsOpenTelemetries(t *testing.T) {
+
+	tracker := NewTracker()
+	c := clock.NewFake(time.Unix(1700000000, 0))
+
+	old := NewTelemetryWithOptions(context.Background(), "test.tracker.old", WithHandlers(tracker), WithClock(c))
+	old.RecordValue("user", "alice")
+	NewTelemetry(old, "test.tracker.nested")
+
+	c.Advance(time.Minute)
+
+	closed := NewTelemetryWithOptions(context.Background(), "test.tracker.closed", WithHandlers(tracker), WithClock(c))
+	closed.Close()
+
+	recent := NewTelemetryWithOptions(context.Background(), "test.tracker.recent", WithHandlers(tracker), WithClock(c))
+	defer recent.Close()
+
+	if active := tracker.Active(); len(active) != 2 || active[0] != old || active[1] != recent {
+		t.Fatalf("Active telemetries are different than expected: %v.", active)
+	}
+
+	recorder := httptest.NewRecorder()
+	tracker.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/telemetry?minAge=30s", nil))
+
+	body := recorder.Body.String()
+
+	if !strings.Contains(body, "test.tracker.old") || !strings.Contains(body, "test.tracker.nested") || !strings.Contains(body, "[user=alice]") {
+		t.Errorf("Old telemetry tree is missing:\n%s", body)
+	}
+
+	if strings.Contains(body, "test.tracker.recent") || strings.Contains(body, "test.tracker.closed") {
+		t.Errorf("Recent or closed telemetries were not filtered out:\n%s", body)
+	}
+
+	recorder = httptest.NewRecorder()
+	tracker.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/telemetry?name=recent", nil))
+
+	if body := recorder.Body.String(); strings.Contains(body, "test.tracker.old") || !strings.Contains(body, "test.tracker.recent") {
+		t.Errorf("Name filter did not apply:\n%s", body)
+	}
+}
@@ -0,0 +1,189 @@
+package telemetry
+
+import (
+	"github.com/israelchen/gomon/util"
+	"sort"
+	"time"
+)
+
+// TreeNode is the analysed form of a single telemetry within a closed telemetry tree. Offsets are
+// relative to the start of the tree's root and, like all durations, marshalled as nanoseconds.
+type TreeNode struct {
+	Name     string        `json:"name"`
+	Path     string        `json:"path"`
+	Offset   time.Duration `json:"offset"`
+	Duration time.Duration `json:"duration"`
+	SelfTime time.Duration `json:"selfTime"`
+	Error    string        `json:"error,omitempty"`
+	Dropped  int64         `json:"droppedChildren,omitempty"`
+	Links    []Link        `json:"links,omitempty"`
+	Critical bool          `json:"critical"`
+	Children []*TreeNode   `json:"children,omitempty"`
+
+	start time.Time
+	end   time.Time
+}
+
+// TreeAnalysis holds the analysed tree together with its critical path and slowest descendants.
+type TreeAnalysis struct {
+	Root         *TreeNode
+	CriticalPath []*TreeNode
+	Slowest      []*TreeNode
+}
+
+// AnalyzeTree computes offsets, durations, self time and the critical path of the tree rooted at
+// root, along with its topN slowest descendants. Telemetries that were still open when the
+// snapshot was taken end at its EndTime.
+func AnalyzeTree(root *SpanData, topN int) *TreeAnalysis {
+	util.Require(root != nil, "telemetry: root cannot be nil.")
+	util.Require(topN >= 0, "telemetry: topN cannot be negative.")
+
+	analysis := &TreeAnalysis{
+		Root: buildTreeNode(root, "", root.StartTime),
+	}
+
+	analysis.Root.Critical = true
+	analysis.CriticalPath = append([]*TreeNode{analysis.Root}, markCriticalPath(analysis.Root)...)
+
+	var descendants []*TreeNode
+
+	walkTree(analysis.Root, func(node *TreeNode, depth int) {
+		if depth > 0 {
+			descendants = append(descendants, node)
+		}
+	})
+
+	sort.SliceStable(descendants, func(i, j int) bool {
+		return descendants[i].Duration > descendants[j].Duration
+	})
+
+	if len(descendants) > topN {
+		descendants = descendants[:topN]
+	}
+
+	analysis.Slowest = descendants
+
+	return analysis
+}
+
+func buildTreeNode(span *SpanData, parentPath string, rootStart time.Time) *TreeNode {
+
+	node := &TreeNode{
+		Name:     span.Name,
+		Path:     span.Name,
+		Offset:   span.StartTime.Sub(rootStart),
+		Duration: span.Elapsed(),
+		Dropped:  span.DroppedChildren,
+		Links:    span.Links,
+		start:    span.StartTime,
+		end:      span.EndTime,
+	}
+
+	if len(parentPath) > 0 {
+		node.Path = parentPath + "/" + node.Name
+	}
+
+	if span.Err != nil {
+		node.Error = span.Err.Error()
+	}
+
+	for _, child := range span.Children {
+		node.Children = append(node.Children, buildTreeNode(child, node.Path, rootStart))
+	}
+
+	node.SelfTime = node.Duration - coveredTime(node)
+
+	return node
+}
+
+// coveredTime returns how much of node's interval is covered by at least one of its children, so
+// that overlapping (concurrent) children are only subtracted once from the node's self time.
+func coveredTime(node *TreeNode) time.Duration {
+
+	intervals := make([][2]time.Time, 0, len(node.Children))
+
+	for _, child := range node.Children {
+		start, end := child.start, child.end
+
+		if start.Before(node.start) {
+			start = node.start
+		}
+
+		if end.After(node.end) {
+			end = node.end
+		}
+
+		if end.After(start) {
+			intervals = append(intervals, [2]time.Time{start, end})
+		}
+	}
+
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i][0].Before(intervals[j][0])
+	})
+
+	var covered time.Duration
+
+	for i := 0; i < len(intervals); {
+		start, end := intervals[i][0], intervals[i][1]
+
+		for i++; i < len(intervals) && !intervals[i][0].After(end); i++ {
+			if intervals[i][1].After(end) {
+				end = intervals[i][1]
+			}
+		}
+
+		covered += end.Sub(start)
+	}
+
+	return covered
+}
+
+// markCriticalPath walks node's children backwards from its end: the child finishing last is what
+// node waited for, then the child finishing last before that one started, and so on. It returns
+// the critical descendants of node in start order.
+func markCriticalPath(node *TreeNode) []*TreeNode {
+
+	var path []*TreeNode
+
+	horizon := node.end
+
+	for {
+		var last *TreeNode
+
+		for _, child := range node.Children {
+			if child.Critical || child.end.After(horizon) {
+				continue
+			}
+
+			if last == nil || child.end.After(last.end) {
+				last = child
+			}
+		}
+
+		if last == nil {
+			break
+		}
+
+		last.Critical = true
+		path = append(append([]*TreeNode{last}, markCriticalPath(last)...), path...)
+		horizon = last.start
+	}
+
+	return path
+}
+
+func walkTree(node *TreeNode, visit func(node *TreeNode, depth int)) {
+
+	var walk func(node *TreeNode, depth int)
+
+	walk = func(node *TreeNode, depth int) {
+		visit(node, depth)
+
+		for _, child := range node.Children {
+			walk(child, depth+1)
+		}
+	}
+
+	walk(node, 0)
+}
@@ -0,0 +1,210 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spanAt overrides the start and end times of t to be offsets from base.
+func spanAt(t *Telemetry, base time.Time, from time.Duration, to time.Duration) {
+	start, end := base.Add(from), base.Add(to)
+	t.startTime, t.endTime = &start, &end
+}
+
+func newTestTree() *Telemetry {
+
+	base := time.Now()
+
+	root := NewTelemetry(context.Background(), "root")
+	auth := NewTelemetry(root, "auth")
+	query := NewTelemetry(root, "query")
+	dial := NewTelemetry(query, "dial")
+	cache := NewTelemetry(root, "cache")
+	render := NewTelemetry(root, "render")
+
+	// auth runs first, then query and cache run concurrently, then render.
+	spanAt(root, base, 0, 100*time.Millisecond)
+	spanAt(auth, base, 0, 10*time.Millisecond)
+	spanAt(query, base, 10*time.Millisecond, 70*time.Millisecond)
+	spanAt(dial, base, 10*time.Millisecond, 30*time.Millisecond)
+	spanAt(cache, base, 15*time.Millisecond, 40*time.Millisecond)
+	spanAt(render, base, 70*time.Millisecond, 90*time.Millisecond)
+
+	return root
+}
+
+func TestAnalyzeTree(t *testing.T) {
+
+	analysis := AnalyzeTree(newTestTree().Snapshot(), 2)
+
+	var paths []string
+
+	for _, node := range analysis.CriticalPath {
+		paths = append(paths, node.Path)
+	}
+
+	if strings.Join(paths, " ") != "root root/auth root/query root/query/dial root/render" {
+		t.Errorf("Critical path is different than expected: %v.", paths)
+	}
+
+	// children cover 0-70ms and 70-90ms, with query and cache overlapping.
+	if analysis.Root.SelfTime != 10*time.Millisecond {
+		t.Errorf("Expected root self time of 10ms, got %v.", analysis.Root.SelfTime)
+	}
+
+	query := analysis.Root.Children[1]
+
+	if query.Offset != 10*time.Millisecond || query.SelfTime != 40*time.Millisecond {
+		t.Errorf("Expected query offset 10ms and self time 40ms, got %v and %v.", query.Offset, query.SelfTime)
+	}
+
+	if len(analysis.Slowest) != 2 || analysis.Slowest[0].Path != "root/query" || analysis.Slowest[1].Path != "root/cache" {
+		t.Errorf("Slowest descendants are different than expected: %v.", analysis.Slowest)
+	}
+}
+
+func TestTreeHandlerText(t *testing.T) {
+
+	var b bytes.Buffer
+
+	root := newTestTree()
+	NewTreeHandler(&b, WithTopN(1)).Ended(root.Snapshot())
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+
+	if len(lines) != 8 {
+		t.Fatalf("Expected 8 lines, got %d:\n%s", len(lines), b.String())
+	}
+
+	if !strings.HasPrefix(lines[3], "*     dial ") {
+		t.Errorf("Expected dial to be indented and critical, got %q.", lines[3])
+	}
+
+	if !strings.HasPrefix(lines[4], "    cache ") {
+		t.Errorf("Expected cache not to be critical, got %q.", lines[4])
+	}
+
+	if !strings.Contains(lines[7], "root/query") {
+		t.Errorf("Expected query to be the slowest descendant, got %q.", lines[7])
+	}
+}
+
+func TestTreeHandlerJSON(t *testing.T) {
+
+	var b bytes.Buffer
+
+	NewTreeHandler(&b, WithTreeFormat(TreeJSON)).Ended(newTestTree().Snapshot())
+
+	var report struct {
+		Root struct {
+			Name     string
+			Children []struct{ Name string }
+		}
+		CriticalPath []string
+		Slowest      []struct{ Path string }
+	}
+
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Root.Name != "root" || len(report.Root.Children) != 4 {
+		t.Errorf("Root is different than expected: %+v.", report.Root)
+	}
+
+	if len(report.CriticalPath) != 5 || len(report.Slowest) != 5 {
+		t.Errorf("Expected 5 critical and 5 slowest nodes, got %d and %d.", len(report.CriticalPath), len(report.Slowest))
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTreeHandlerWriteError(t *testing.T) {
+
+	for _, format := range []TreeFormat{TreeText, TreeJSON} {
+		var errs []error
+
+		NewTreeHandler(failingWriter{}, WithTreeFormat(format), WithTreeErrorHandler(func(err error) {
+			errs = append(errs, err)
+		})).Ended(newTestTree().Snapshot())
+
+		if len(errs) != 1 || errs[0].Error() != "root: disk full" {
+			t.Errorf("Expected the write error to be reported once, got %v.", errs)
+		}
+	}
+}
+
+func TestTreeHandlerOnClose(t *testing.T) {
+
+	var b bytes.Buffer
+
+	root := NewTelemetryWithOptions(context.Background(), "root", WithHandlers(NewTreeHandler(&b)), WithLimits(Limits{MaxChildren: 1}))
+	NewTelemetry(root, "query")
+	NewTelemetry(root, "dropped")
+
+	closed := make(chan struct{})
+
+	go func() {
+		root.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return with a TreeHandler attached.")
+	}
+
+	if !strings.Contains(b.String(), "query") || !strings.Contains(b.String(), "(1 children dropped)") {
+		t.Errorf("Tree is different than expected:\n%s", b.String())
+	}
+}
+
+func TestTreeHandlerLinks(t *testing.T) {
+
+	request := NewTelemetry(context.Background(), "request")
+	batch := NewTelemetry(context.Background(), "batch")
+	message := NewTelemetry(batch, "message")
+
+	message.AddLink(request.SpanContext(), "queue", "orders")
+	message.Close()
+
+	if len(batch.Snapshot().Children[0].Links) != 1 || message.Links()[0].TraceID == batch.TraceID() {
+		t.Fatal("Link was not recorded.")
+	}
+
+	var text, js bytes.Buffer
+
+	NewTreeHandler(&text, WithTopN(0)).Ended(batch.Snapshot())
+	NewTreeHandler(&js, WithTreeFormat(TreeJSON)).Ended(batch.Snapshot())
+
+	lines := strings.Split(strings.TrimSpace(text.String()), "\n")
+	expected := "      -> link " + request.SpanContext().String() + " [queue=orders]"
+
+	if len(lines) != 3 || lines[2] != expected {
+		t.Errorf("Expected the link below message, got:\n%s", text.String())
+	}
+
+	var report struct {
+		Root struct {
+			Children []struct{ Links []map[string]interface{} }
+		}
+	}
+
+	if err := json.Unmarshal(js.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if links := report.Root.Children[0].Links; len(links) != 1 || links[0]["traceId"] != request.TraceID().String() || links[0]["spanId"] != request.SpanID().String() {
+		t.Errorf("Links are different than expected: %s", js.String())
+	}
+}
@@ -0,0 +1,230 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/israelchen/gomon/util"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TreeFormat int
+
+const (
+	TreeText TreeFormat = iota
+	TreeJSON
+)
+
+const (
+	defaultTreeTopN  = 5
+	treeWaterfallLen = 40
+)
+
+// TreeHandler renders the telemetry it is attached to, once closed, as a waterfall of its whole
+// tree including the critical path, self time per node and the slowest descendants.
+//
+// Errors writing the tree are logged with slog unless an error handler is given with
+// WithTreeErrorHandler.
+type TreeHandler struct {
+	writer  io.Writer
+	format  TreeFormat
+	topN    int
+	onError func(err error)
+	mu      sync.Mutex
+}
+
+type TreeHandlerOption func(handler *TreeHandler)
+
+func WithTreeFormat(format TreeFormat) TreeHandlerOption {
+	util.Require(format == TreeText || format == TreeJSON, "telemetry: unknown tree format.")
+
+	return func(handler *TreeHandler) {
+		handler.format = format
+	}
+}
+
+// WithTopN sets how many of the slowest descendants are reported.
+func WithTopN(topN int) TreeHandlerOption {
+	util.Require(topN >= 0, "telemetry: topN cannot be negative.")
+
+	return func(handler *TreeHandler) {
+		handler.topN = topN
+	}
+}
+
+// WithTreeErrorHandler calls onError with the errors met writing trees, instead of logging them.
+func WithTreeErrorHandler(onError func(err error)) TreeHandlerOption {
+	util.Require(onError != nil, "telemetry: onError cannot be nil.")
+
+	return func(handler *TreeHandler) {
+		handler.onError = onError
+	}
+}
+
+func NewTreeHandler(writer io.Writer, options ...TreeHandlerOption) *TreeHandler {
+	util.Require(writer != nil, "telemetry: writer cannot be nil.")
+
+	handler := &TreeHandler{
+		writer: writer,
+		format: TreeText,
+		topN:   defaultTreeTopN,
+		onError: func(err error) {
+			slog.Warn("telemetry: writing tree failed.", "error", err)
+		},
+	}
+
+	for _, option := range options {
+		option(handler)
+	}
+
+	return handler
+}
+
+func (self *TreeHandler) Started(t *Telemetry) {
+	util.Require(t != nil, "telemetry: telemetry cannot be nil.")
+}
+
+func (self *TreeHandler) Ended(span *SpanData) {
+	util.Require(span != nil, "telemetry: span cannot be nil.")
+
+	analysis := AnalyzeTree(span, self.topN)
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	var err error
+
+	if self.format == TreeJSON {
+		err = WriteTreeJSON(self.writer, analysis)
+	} else {
+		err = WriteTreeText(self.writer, analysis)
+	}
+
+	if err != nil {
+		self.onError(fmt.Errorf("%s: %w", span.Name, err))
+	}
+}
+
+type treeReport struct {
+	Root         *TreeNode        `json:"root"`
+	CriticalPath []string         `json:"criticalPath"`
+	Slowest      []treeReportNode `json:"slowest"`
+}
+
+type treeReportNode struct {
+	Path     string        `json:"path"`
+	Duration time.Duration `json:"duration"`
+	SelfTime time.Duration `json:"selfTime"`
+}
+
+// WriteTreeJSON writes analysis as a single line of JSON.
+func WriteTreeJSON(writer io.Writer, analysis *TreeAnalysis) error {
+	util.Require(writer != nil, "telemetry: writer cannot be nil.")
+	util.Require(analysis != nil, "telemetry: analysis cannot be nil.")
+
+	report := treeReport{
+		Root:         analysis.Root,
+		CriticalPath: make([]string, 0, len(analysis.CriticalPath)),
+		Slowest:      make([]treeReportNode, 0, len(analysis.Slowest)),
+	}
+
+	for _, node := range analysis.CriticalPath {
+		report.CriticalPath = append(report.CriticalPath, node.Path)
+	}
+
+	for _, node := range analysis.Slowest {
+		report.Slowest = append(report.Slowest, treeReportNode{node.Path, node.Duration, node.SelfTime})
+	}
+
+	return json.NewEncoder(writer).Encode(report)
+}
+
+// WriteTreeText writes analysis as an indented waterfall. Nodes on the critical path are marked
+// with an asterisk, and their links are listed below them.
+func WriteTreeText(writer io.Writer, analysis *TreeAnalysis) error {
+	util.Require(writer != nil, "telemetry: writer cannot be nil.")
+	util.Require(analysis != nil, "telemetry: analysis cannot be nil.")
+
+	total := analysis.Root.Duration
+	nameWidth := 0
+
+	walkTree(analysis.Root, func(node *TreeNode, depth int) {
+		if width := 2*depth + len(node.Name); width > nameWidth {
+			nameWidth = width
+		}
+	})
+
+	var b strings.Builder
+
+	walkTree(analysis.Root, func(node *TreeNode, depth int) {
+		marker := " "
+
+		if node.Critical {
+			marker = "*"
+		}
+
+		name := strings.Repeat("  ", depth) + node.Name
+
+		line := fmt.Sprintf("%s %-*s |%s| +%-10v %-10v self %-10v", marker, nameWidth, name, waterfall(node, total), node.Offset, node.Duration, node.SelfTime)
+
+		if len(node.Error) > 0 {
+			line += " error: " + node.Error
+		}
+
+		if node.Dropped > 0 {
+			line += fmt.Sprintf(" (%d children dropped)", node.Dropped)
+		}
+
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+
+		for _, link := range node.Links {
+			fmt.Fprintf(&b, "  %s  -> link %s", strings.Repeat("  ", depth), link)
+
+			for _, attribute := range formatAttributes(link.Attributes) {
+				fmt.Fprintf(&b, " [%s]", attribute)
+			}
+
+			b.WriteString("\n")
+		}
+	})
+
+	if len(analysis.Slowest) > 0 {
+		b.WriteString("slowest:\n")
+
+		for i, node := range analysis.Slowest {
+			fmt.Fprintf(&b, "%3d. %-10v %s\n", i+1, node.Duration, node.Path)
+		}
+	}
+
+	_, err := io.WriteString(writer, b.String())
+	return err
+}
+
+func waterfall(node *TreeNode, total time.Duration) string {
+
+	bar := []byte(strings.Repeat(" ", treeWaterfallLen))
+
+	if total <= 0 {
+		return string(bar)
+	}
+
+	from := int(int64(node.Offset) * treeWaterfallLen / int64(total))
+	to := int(int64(node.Offset+node.Duration) * treeWaterfallLen / int64(total))
+
+	if to > treeWaterfallLen {
+		to = treeWaterfallLen
+	}
+
+	if to <= from && from < treeWaterfallLen {
+		to = from + 1
+	}
+
+	for i := from; i < to; i++ {
+		bar[i] = '='
+	}
+
+	return string(bar)
+}